@@ -126,6 +126,9 @@ func setupEnabled() {
 		if !isMember && !isGroupMember {
 			return true, "only pyramid or group members can upload blobs", 403
 		}
+		if pyramid.IsSuspended(auth.PubKey, pyramid.ScopeBlossom) {
+			return true, "you are suspended from uploading blobs", 403
+		}
 
 		// check user upload size limit
 		if !pyramid.IsRoot(auth.PubKey) {
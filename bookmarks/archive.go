@@ -0,0 +1,185 @@
+package bookmarks
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/eventstore/mmm"
+	"github.com/puzpuzpuz/xsync/v3"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/linkpreview"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+// snapshots of bookmarked pages are kept as addressable events signed by the relay,
+// with the page URL as the "d" tag and its readable text as content
+const archiveKind = 30078
+
+var (
+	// at most this many pages are fetched at the same time
+	archiving = make(chan struct{}, 2)
+	inFlight  = xsync.NewMapOf[string, struct{}]()
+)
+
+// Archived returns the snapshot of a web page, if there is one.
+func Archived(url string) *nostr.Event {
+	for evt := range archiveDB.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{archiveKind},
+		Authors: []nostr.PubKey{global.Settings.RelayInternalSecretKey.Public()},
+		Tags:    nostr.TagMap{"d": []string{url}},
+	}, 1) {
+		return &evt
+	}
+	return nil
+}
+
+// archiveLookup reads the text of archived pages only for the URLs a query asks about,
+// remembering them so each page is read at most once per query.
+type archiveLookup map[string]string
+
+func (a archiveLookup) text(url string) string {
+	if text, ok := a[url]; ok {
+		return text
+	}
+	var text string
+	if evt := Archived(url); evt != nil {
+		text = evt.Content
+		if title := evt.Tags.Find("title"); title != nil {
+			text = title[1] + "\n" + text
+		}
+	}
+	a[url] = text
+	return text
+}
+
+// archiveBookmarks takes snapshots of the web pages bookmarked in an event that aren't archived yet.
+func archiveBookmarks(evt nostr.Event) {
+	if !global.Settings.Bookmarks.Archive {
+		return
+	}
+	for _, b := range bookmarksIn(evt) {
+		if b.URL != "" {
+			go archive(b.URL)
+		}
+	}
+}
+
+// ArchiveAll takes snapshots of all the web pages members bookmarked that aren't archived yet.
+func ArchiveAll() {
+	dbs := slices.Collect(func(yield func(*mmm.IndexingLayer) bool) {
+		for pubkey := range membersWithBookmarks() {
+			if db := getDB(pubkey); db != nil && !yield(db) {
+				return
+			}
+		}
+		for _, tr := range teamRelays.Range {
+			if !yield(tr.db) {
+				return
+			}
+		}
+	})
+	for _, db := range dbs {
+		for _, b := range userBookmarks(db) {
+			if !global.Settings.Bookmarks.Archive {
+				return
+			}
+			if b.URL != "" {
+				archive(b.URL)
+			}
+		}
+	}
+}
+
+func archive(url string) {
+	if _, loaded := inFlight.LoadOrStore(url, struct{}{}); loaded {
+		return
+	}
+	defer inFlight.Delete(url)
+
+	if Archived(url) != nil {
+		return
+	}
+
+	archiving <- struct{}{}
+	defer func() { <-archiving }()
+
+	snapshot, err := linkpreview.TakeSnapshot(url)
+	if err != nil {
+		log.Debug().Err(err).Str("url", url).Msg("failed to archive bookmarked page")
+		return
+	}
+
+	evt := nostr.Event{
+		Kind:      archiveKind,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"d", url},
+			{"r", url},
+			{"alt", "archived copy of " + url},
+		},
+		Content: snapshot.Text,
+	}
+	if snapshot.Title != "" {
+		evt.Tags = append(evt.Tags, nostr.Tag{"title", snapshot.Title})
+	}
+	if snapshot.Description != "" {
+		evt.Tags = append(evt.Tags, nostr.Tag{"summary", snapshot.Description})
+	}
+	if snapshot.Image != "" {
+		evt.Tags = append(evt.Tags, nostr.Tag{"image", snapshot.Image})
+	}
+
+	if err := evt.Sign(global.Settings.RelayInternalSecretKey); err != nil {
+		return
+	}
+	if _, err := archiveDB.ReplaceEvent(evt); err != nil {
+		log.Warn().Err(err).Str("url", url).Msg("failed to save archived page")
+		return
+	}
+	log.Info().Str("url", url).Int("size", len(snapshot.Text)).Msg("archived bookmarked page")
+}
+
+func hasBookmarked(pubkey nostr.PubKey, url string) bool {
+	dbs := make([]*mmm.IndexingLayer, 0, 4)
+	if db := getDB(pubkey); db != nil {
+		dbs = append(dbs, db)
+	}
+	for _, team := range teamsFor(pubkey) {
+		if tr, ok := teamRelays.Load(team.ID); ok {
+			dbs = append(dbs, tr.db)
+		}
+	}
+
+	for _, db := range dbs {
+		if slices.ContainsFunc(userBookmarks(db), func(b Bookmark) bool { return b.URL == url }) {
+			return true
+		}
+	}
+	return false
+}
+
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsMember(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	// members can only see the pages they or their teams bookmarked
+	url := r.URL.Query().Get("url")
+	if !hasBookmarked(loggedUser, url) {
+		http.Error(w, "you haven't bookmarked this page", 404)
+		return
+	}
+
+	snapshot := Archived(url)
+	if snapshot == nil {
+		http.Error(w, fmt.Sprintf("%s wasn't archived", url), 404)
+		return
+	}
+
+	archivePage(loggedUser, url, *snapshot).Render(r.Context(), w)
+}
@@ -2,6 +2,10 @@ package bookmarks
 
 import (
 	"errors"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 
 	"fiatjaf.com/nostr"
@@ -13,9 +17,10 @@ import (
 const MaxUserDatabases = 5_000
 
 var (
-	allDB    *mmm.IndexingLayer
-	userDBs  = xsync.NewMapOf[nostr.PubKey, *mmm.IndexingLayer]()
-	ensureMu sync.Mutex
+	allDB     *mmm.IndexingLayer
+	archiveDB *mmm.IndexingLayer
+	userDBs   = xsync.NewMapOf[nostr.PubKey, *mmm.IndexingLayer]()
+	ensureMu  sync.Mutex
 )
 
 func initDatabases() error {
@@ -24,14 +29,46 @@ func initDatabases() error {
 		return err
 	}
 	allDB = layer
+
+	layer, err = global.MMMM.EnsureLayer("bookmarks/archive")
+	if err != nil {
+		return err
+	}
+	archiveDB = layer
+
 	return nil
 }
 
+func userLayerName(pubkey nostr.PubKey) string {
+	return "bookmarks/user-" + pubkey.Hex()
+}
+
+// getDB returns the layer of a member that has bookmarks, opening it the first time it's needed.
 func getDB(pubkey nostr.PubKey) *mmm.IndexingLayer {
-	db, _ := userDBs.Load(pubkey)
+	if db, ok := userDBs.Load(pubkey); ok {
+		return db
+	}
+	if _, err := os.Stat(filepath.Join(global.MMMM.Dir, userLayerName(pubkey))); err != nil {
+		return nil
+	}
+	db, _ := ensureDB(pubkey)
 	return db
 }
 
+// membersWithBookmarks goes through the members that have a bookmarks layer on disk, without opening them.
+func membersWithBookmarks() iter.Seq[nostr.PubKey] {
+	return func(yield func(nostr.PubKey) bool) {
+		entries, _ := os.ReadDir(filepath.Join(global.MMMM.Dir, "bookmarks"))
+		for _, entry := range entries {
+			if pubkeyHex, ok := strings.CutPrefix(entry.Name(), "user-"); ok && entry.IsDir() {
+				if pubkey, err := nostr.PubKeyFromHex(pubkeyHex); err == nil && !yield(pubkey) {
+					return
+				}
+			}
+		}
+	}
+}
+
 func ensureDB(pubkey nostr.PubKey) (*mmm.IndexingLayer, error) {
 	if db, ok := userDBs.Load(pubkey); ok {
 		return db, nil
@@ -48,7 +85,7 @@ func ensureDB(pubkey nostr.PubKey) (*mmm.IndexingLayer, error) {
 		return nil, errors.New("bookmarks: max user databases reached")
 	}
 
-	layer, err := global.MMMM.EnsureLayer("bookmarks/user-" + pubkey.Hex())
+	layer, err := global.MMMM.EnsureLayer(userLayerName(pubkey))
 	if err != nil {
 		log.Error().Err(err).Str("pubkey", pubkey.Hex()).Msg("failed to setup bookmarks user indexing layer")
 		return nil, errors.New("bookmarks: failed to create user database")
@@ -34,6 +34,8 @@ func Init() {
 		return
 	}
 
+	setupTeams()
+
 	if global.Settings.Bookmarks.Enabled {
 		setupEnabled()
 	} else {
@@ -46,10 +48,7 @@ func setupDisabled() {
 	global.CleanupRelay(AllRelay)
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/"+global.Settings.Bookmarks.HTTPBasePath+"/", func(w http.ResponseWriter, r *http.Request) {
-		loggedUser, _ := global.GetLoggedUser(r)
-		bookmarksPage(loggedUser).Render(r.Context(), w)
-	})
+	mux.HandleFunc("/"+global.Settings.Bookmarks.HTTPBasePath+"/", pageHandler)
 	mux.HandleFunc("POST /"+global.Settings.Bookmarks.HTTPBasePath+"/enable", enableHandler)
 	Relay.SetRouter(mux)
 }
@@ -71,6 +70,7 @@ func setupEnabled() {
 		info.Icon = global.Settings.Bookmarks.GetIcon()
 		info.Contact = global.Settings.RelayContact
 		info.Software = "https://github.com/fiatjaf/pyramid"
+		info.SupportedNIPs = append(info.SupportedNIPs, 50)
 		return info
 	}
 
@@ -98,7 +98,6 @@ func setupEnabled() {
 
 	Relay.OnRequest = policies.SeqRequest(
 		policies.NoComplexFilters,
-		policies.NoSearchQueries,
 		policies.FilterIPRateLimiter(20, time.Minute, 100),
 		global.RejectTooManyOpenSubscriptions,
 		func(ctx context.Context, filter nostr.Filter) (bool, string) {
@@ -174,11 +173,11 @@ func setupEnabled() {
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/"+global.Settings.Bookmarks.HTTPBasePath+"/", func(w http.ResponseWriter, r *http.Request) {
-		loggedUser, _ := global.GetLoggedUser(r)
-		bookmarksPage(loggedUser).Render(r.Context(), w)
-	})
+	mux.HandleFunc("/"+global.Settings.Bookmarks.HTTPBasePath+"/", pageHandler)
 	mux.HandleFunc("POST /"+global.Settings.Bookmarks.HTTPBasePath+"/disable", disableHandler)
+	mux.HandleFunc("GET /"+global.Settings.Bookmarks.HTTPBasePath+"/archive", archiveHandler)
+	mux.HandleFunc("POST /"+global.Settings.Bookmarks.HTTPBasePath+"/teams", teamsHandler)
+	mux.HandleFunc("POST /"+global.Settings.Bookmarks.HTTPBasePath+"/teams/delete", deleteTeamHandler)
 	Relay.SetRouter(mux)
 }
 
@@ -193,6 +192,10 @@ func query(ctx context.Context, filter nostr.Filter) iter.Seq[nostr.Event] {
 		return func(yield func(nostr.Event) bool) {}
 	}
 
+	if filter.Search != "" {
+		return search(ctx, userDB, filter)
+	}
+
 	return userDB.QueryEvents(filter, global.Settings.Limits.MaxQueryLimit)
 }
 
@@ -223,6 +226,7 @@ func storeEvent(ctx context.Context, event nostr.Event) error {
 	}
 
 	AllRelay.BroadcastEvent(event)
+	archiveBookmarks(event)
 	return nil
 }
 
@@ -253,6 +257,7 @@ func replaceEvent(ctx context.Context, event nostr.Event) error {
 	}
 
 	AllRelay.BroadcastEvent(event)
+	archiveBookmarks(event)
 	return nil
 }
 
@@ -272,6 +277,24 @@ func deleteEvent(ctx context.Context, id nostr.ID) error {
 	return nil
 }
 
+func pageHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+
+	view := searchView{
+		BaseURL: global.Settings.Bookmarks.GetPageURL(),
+		Query:   r.URL.Query().Get("q"),
+		Tag:     r.URL.Query().Get("tag"),
+		Folder:  r.URL.Query().Get("folder"),
+	}
+	if db := getDB(loggedUser); db != nil && pyramid.IsMember(loggedUser) {
+		all := userBookmarks(db)
+		view.Tags, view.Folders = facets(all)
+		view.Bookmarks = filterBookmarks(all, view.Query, view.Tag, view.Folder)
+	}
+
+	bookmarksPage(loggedUser, view).Render(r.Context(), w)
+}
+
 func enableHandler(w http.ResponseWriter, r *http.Request) {
 	loggedUser, _ := global.GetLoggedUser(r)
 
@@ -0,0 +1,225 @@
+package bookmarks
+
+import (
+	"cmp"
+	"context"
+	"iter"
+	"slices"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/eventstore/mmm"
+
+	"github.com/fiatjaf/pyramid/global"
+)
+
+// the kinds a member's bookmarks come from: their bookmark list, their bookmark sets
+// (which are shown as folders) and their web bookmarks
+var bookmarkKinds = []nostr.Kind{10003, 30003, 39701}
+
+// Bookmark is one thing a member bookmarked, either a web page or a nostr event.
+type Bookmark struct {
+	URL       string
+	Reference string // an event id or address
+	Title     string
+	Note      string
+	Tags      []string
+	Folder    string
+	CreatedAt nostr.Timestamp
+}
+
+type Facet struct {
+	Name  string
+	Count int
+}
+
+// bookmarksIn lists the bookmarks inside an event, if it is one of the bookmark kinds.
+func bookmarksIn(evt nostr.Event) []Bookmark {
+	switch evt.Kind {
+	case 39701:
+		b := Bookmark{
+			URL:       webBookmarkURL(evt.Tags.GetD()),
+			Note:      evt.Content,
+			CreatedAt: evt.CreatedAt,
+		}
+		if title := evt.Tags.Find("title"); title != nil {
+			b.Title = title[1]
+		}
+		for _, tag := range evt.Tags {
+			if len(tag) >= 2 && tag[0] == "t" {
+				b.Tags = append(b.Tags, strings.ToLower(tag[1]))
+			}
+		}
+		return []Bookmark{b}
+	case 10003, 30003:
+		var folder string
+		if evt.Kind == 30003 {
+			folder = evt.Tags.GetD()
+			if title := evt.Tags.Find("title"); title != nil && title[1] != "" {
+				folder = title[1]
+			}
+		}
+
+		bookmarks := make([]Bookmark, 0, len(evt.Tags))
+		for _, tag := range evt.Tags {
+			if len(tag) < 2 {
+				continue
+			}
+			b := Bookmark{Folder: folder, CreatedAt: evt.CreatedAt}
+			switch tag[0] {
+			case "r":
+				b.URL = tag[1]
+			case "e", "a":
+				b.Reference = tag[1]
+			default:
+				continue
+			}
+			bookmarks = append(bookmarks, b)
+		}
+		return bookmarks
+	}
+	return nil
+}
+
+// web bookmarks have their URLs without the scheme in the "d" tag
+func webBookmarkURL(d string) string {
+	if strings.Contains(d, "://") {
+		return d
+	}
+	return "https://" + d
+}
+
+// userBookmarks lists all bookmarks of a member or a team, the latest first.
+func userBookmarks(db *mmm.IndexingLayer) []Bookmark {
+	relayPubKey := global.Settings.RelayInternalSecretKey.Public()
+	bookmarks := make([]Bookmark, 0, 100)
+	for evt := range db.QueryEvents(nostr.Filter{Kinds: bookmarkKinds}, 10_000) {
+		if evt.PubKey == relayPubKey {
+			// the sets of team collections
+			continue
+		}
+		bookmarks = append(bookmarks, bookmarksIn(evt)...)
+	}
+	slices.SortStableFunc(bookmarks, func(a, b Bookmark) int { return cmp.Compare(b.CreatedAt, a.CreatedAt) })
+	return bookmarks
+}
+
+// facets counts how many bookmarks have each tag and are in each folder.
+func facets(bookmarks []Bookmark) (tags []Facet, folders []Facet) {
+	tagCounts := make(map[string]int)
+	folderCounts := make(map[string]int)
+	for _, b := range bookmarks {
+		for _, t := range b.Tags {
+			tagCounts[t]++
+		}
+		if b.Folder != "" {
+			folderCounts[b.Folder]++
+		}
+	}
+	return sortedFacets(tagCounts), sortedFacets(folderCounts)
+}
+
+func sortedFacets(counts map[string]int) []Facet {
+	list := make([]Facet, 0, len(counts))
+	for name, count := range counts {
+		list = append(list, Facet{name, count})
+	}
+	slices.SortFunc(list, func(a, b Facet) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return list
+}
+
+// searchTerms splits a NIP-50 query into lowercase terms, ignoring "key:value" extensions.
+func searchTerms(query string) []string {
+	terms := make([]string, 0, 4)
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		if key, _, isExtension := strings.Cut(term, ":"); isExtension && !strings.Contains(term, "/") && key != "" {
+			continue
+		}
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+func containsAll(text string, terms []string) bool {
+	text = strings.ToLower(text)
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches tells if a bookmark has all the terms, looking at the text of its archived page
+// only when the bookmark itself doesn't have them.
+func (b Bookmark) matches(terms []string, archived archiveLookup) bool {
+	text := strings.Join([]string{b.URL, b.Reference, b.Title, b.Note, b.Folder, strings.Join(b.Tags, " ")}, "\n")
+	if containsAll(text, terms) {
+		return true
+	}
+	return b.URL != "" && containsAll(text+"\n"+archived.text(b.URL), terms)
+}
+
+// matchesEvent tells if a bookmark event or any of the bookmarks inside it has all the terms.
+func matchesEvent(evt nostr.Event, terms []string, archived archiveLookup) bool {
+	if containsAll(evt.Content, terms) {
+		return true
+	}
+	for _, b := range bookmarksIn(evt) {
+		if b.matches(terms, archived) {
+			return true
+		}
+	}
+	return false
+}
+
+// search answers a NIP-50 query over the bookmarks of a member.
+func search(ctx context.Context, db *mmm.IndexingLayer, filter nostr.Filter) iter.Seq[nostr.Event] {
+	return func(yield func(nostr.Event) bool) {
+		terms := searchTerms(filter.Search)
+		limit := global.Settings.Limits.MaxQueryLimit
+		if filter.Limit > 0 && filter.Limit < limit {
+			limit = filter.Limit
+		}
+
+		archived := make(archiveLookup)
+		filter.Search = ""
+		filter.Limit = 0
+		count := 0
+		for evt := range db.QueryEvents(filter, 10_000) {
+			if ctx.Err() != nil {
+				return
+			}
+			if !matchesEvent(evt, terms, archived) {
+				continue
+			}
+			if !yield(evt) {
+				return
+			}
+			count++
+			if count >= limit {
+				return
+			}
+		}
+	}
+}
+
+// filterBookmarks narrows a member's bookmarks down to a search, a tag and a folder.
+func filterBookmarks(bookmarks []Bookmark, query string, tag string, folder string) []Bookmark {
+	terms := searchTerms(query)
+	archived := make(archiveLookup)
+	return slices.DeleteFunc(bookmarks, func(b Bookmark) bool {
+		if tag != "" && !slices.Contains(b.Tags, tag) {
+			return true
+		}
+		if folder != "" && b.Folder != folder {
+			return true
+		}
+		return len(terms) > 0 && !b.matches(terms, archived)
+	})
+}
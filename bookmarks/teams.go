@@ -0,0 +1,397 @@
+package bookmarks
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/eventstore/mmm"
+	"fiatjaf.com/nostr/khatru"
+	"fiatjaf.com/nostr/khatru/policies"
+	"fiatjaf.com/nostr/nip11"
+	"github.com/puzpuzpuz/xsync/v3"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+// the "d" tag of the relay-signed NIP-51 bookmark set of each team collection starts with this
+const TeamSetPrefix = "team-"
+
+type teamRelay struct {
+	id    string
+	relay *khatru.Relay
+	db    *mmm.IndexingLayer
+}
+
+var teamRelays = xsync.NewMapOf[string, teamRelay]()
+
+// TeamsHandler serves each team collection as a relay of its own at /<bookmarks>/team/<id>.
+var TeamsHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if tr, ok := teamRelayAt(r.URL.Path); ok && global.Settings.Bookmarks.Enabled {
+		tr.relay.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+})
+
+// TeamRelayAt finds the relay of a team collection from a path like /team/<id>, as seen in the bookmarks domain.
+func TeamRelayAt(path string) (*khatru.Relay, string, bool) {
+	id, _, _ := strings.Cut(strings.TrimPrefix(path, "/team/"), "/")
+	if tr, ok := teamRelays.Load(id); ok {
+		return tr.relay, global.Settings.Bookmarks.HTTPBasePath + "/team/" + id, true
+	}
+	return nil, "", false
+}
+
+func teamRelayAt(path string) (teamRelay, bool) {
+	id, _, _ := strings.Cut(strings.TrimPrefix(path, "/"+global.Settings.Bookmarks.HTTPBasePath+"/team/"), "/")
+	return teamRelays.Load(id)
+}
+
+func findTeam(id string) (global.TeamCollection, bool) {
+	idx := slices.IndexFunc(global.Settings.Bookmarks.Teams, func(t global.TeamCollection) bool { return t.ID == id })
+	if idx == -1 {
+		return global.TeamCollection{}, false
+	}
+	return global.Settings.Bookmarks.Teams[idx], true
+}
+
+// canAccessTeam tells if a member can read and write to a team collection.
+func canAccessTeam(team global.TeamCollection, pubkey nostr.PubKey) bool {
+	if pyramid.IsRoot(pubkey) || team.Owner == pubkey {
+		return true
+	}
+	if !pyramid.IsMember(pubkey) {
+		return false
+	}
+	return slices.Contains(team.Members, pubkey) || (team.Role != "" && pyramid.MemberHasRole(pubkey, team.Role))
+}
+
+// canManageTeam tells if someone can change who has access to a team collection or delete it.
+func canManageTeam(team global.TeamCollection, pubkey nostr.PubKey) bool {
+	return pyramid.IsRoot(pubkey) || (team.Owner == pubkey && pyramid.IsMember(pubkey))
+}
+
+func teamsFor(pubkey nostr.PubKey) []global.TeamCollection {
+	teams := make([]global.TeamCollection, 0, len(global.Settings.Bookmarks.Teams))
+	for _, team := range global.Settings.Bookmarks.Teams {
+		if canAccessTeam(team, pubkey) {
+			teams = append(teams, team)
+		}
+	}
+	return teams
+}
+
+func teamLayerName(id string) string {
+	return "bookmarks/team-" + id
+}
+
+// setupTeams starts the relays of team collections that don't have one yet (or have moved) and stops those that were deleted.
+func setupTeams() {
+	for _, team := range global.Settings.Bookmarks.Teams {
+		if tr, ok := teamRelays.Load(team.ID); ok && tr.relay.ServiceURL == global.Settings.Bookmarks.GetServiceURL()+"/team/"+team.ID {
+			// already running, and the bookmarks relay hasn't moved
+			continue
+		}
+		if err := setupTeam(team); err != nil {
+			log.Error().Err(err).Str("team", team.ID).Msg("failed to set up team collection")
+		}
+	}
+	for id := range teamRelays.Range {
+		if _, ok := findTeam(id); !ok {
+			teamRelays.Delete(id)
+		}
+	}
+}
+
+func setupTeam(team global.TeamCollection) error {
+	db, err := global.MMMM.EnsureLayer(teamLayerName(team.ID))
+	if err != nil {
+		return err
+	}
+
+	id := team.ID
+	relay := global.NewRelay()
+	relay.ServiceURL = global.Settings.Bookmarks.GetServiceURL() + "/team/" + id
+
+	relay.OverwriteRelayInformation = func(ctx context.Context, r *http.Request, info nip11.RelayInformationDocument) nip11.RelayInformationDocument {
+		team, _ := findTeam(id)
+		info.Name = global.Settings.Bookmarks.GetName() + "/" + team.Name
+		info.Description = "bookmarks shared by a team at " + global.Settings.Bookmarks.GetName()
+		info.Icon = global.Settings.Bookmarks.GetIcon()
+		info.Contact = global.Settings.RelayContact
+		info.Software = "https://github.com/fiatjaf/pyramid"
+		info.SupportedNIPs = append(info.SupportedNIPs, 50)
+		return info
+	}
+
+	relay.UseEventstore(db, global.Settings.Limits.MaxQueryLimit)
+	relay.QueryStored = func(ctx context.Context, filter nostr.Filter) iter.Seq[nostr.Event] {
+		if filter.Search != "" {
+			return search(ctx, db, filter)
+		}
+		return db.QueryEvents(filter, global.Settings.Limits.MaxQueryLimit)
+	}
+
+	pk := global.Settings.RelayInternalSecretKey.Public()
+	relay.Info.Self = &pk
+	relay.Info.PubKey = &pk
+
+	hasAccess := func(ctx context.Context) (bool, string) {
+		team, ok := findTeam(id)
+		if !ok {
+			return true, "blocked: this collection was deleted"
+		}
+		authedPublicKeys := khatru.GetAllAuthed(ctx)
+		if len(authedPublicKeys) == 0 {
+			return true, "auth-required: this collection is only for its team"
+		}
+		for _, authed := range authedPublicKeys {
+			if canAccessTeam(team, authed) {
+				return false, ""
+			}
+		}
+		return true, "restricted: you're not in this team"
+	}
+
+	relay.OnRequest = policies.SeqRequest(
+		policies.NoComplexFilters,
+		policies.FilterIPRateLimiter(20, time.Minute, 100),
+		global.RejectTooManyOpenSubscriptions,
+		func(ctx context.Context, filter nostr.Filter) (bool, string) {
+			return hasAccess(ctx)
+		},
+	)
+
+	relay.OnEvent = policies.SeqEvent(
+		global.RejectInternalKinds,
+		policies.PreventLargeContent(global.Settings.Limits.MaxEventSize),
+		policies.PreventTooManyIndexableTags(global.Settings.Limits.MaxIndexableTags, nil, nil),
+		func(ctx context.Context, evt nostr.Event) (bool, string) {
+			if !slices.Contains(bookmarkKinds, evt.Kind) && evt.Kind != nostr.KindDeletion {
+				return true, "blocked: only bookmarks can be saved here"
+			}
+			// the author must be in the team too, not only whoever is connected
+			team, ok := findTeam(id)
+			if !ok {
+				return true, "blocked: this collection was deleted"
+			}
+			if !khatru.IsAuthed(ctx, evt.PubKey) {
+				return true, "auth-required: you must be authenticated as the author of the event"
+			}
+			if !canAccessTeam(team, evt.PubKey) {
+				return true, "restricted: the author is not in this team"
+			}
+			return false, ""
+		},
+	)
+
+	tr := teamRelay{id: id, relay: relay, db: db}
+	relay.OnEventSaved = func(ctx context.Context, event nostr.Event) {
+		archiveBookmarks(event)
+		publishTeamSet(tr)
+	}
+	relay.OnEventDeleted = func(ctx context.Context, deleted nostr.Event) {
+		publishTeamSet(tr)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+global.Settings.Bookmarks.HTTPBasePath+"/team/"+id, teamPageHandler)
+	mux.HandleFunc("/"+global.Settings.Bookmarks.HTTPBasePath+"/team/"+id+"/", teamPageHandler)
+	relay.SetRouter(mux)
+
+	teamRelays.Store(id, tr)
+	return nil
+}
+
+// publishTeamSet publishes the NIP-51 bookmark set (kind 30003) with everything in a team collection, the latest first.
+func publishTeamSet(tr teamRelay) {
+	id := tr.id
+	team, ok := findTeam(id)
+	if !ok {
+		return
+	}
+
+	relayPubKey := global.Settings.RelayInternalSecretKey.Public()
+	set := nostr.Event{
+		Kind:      30003,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"d", TeamSetPrefix + id},
+			{"title", team.Name},
+			{"description", fmt.Sprintf("bookmarks shared by the %s team at %s", team.Name, global.Settings.RelayName)},
+			{"alt", "bookmark set: " + team.Name},
+		},
+	}
+	for evt := range tr.db.QueryEvents(nostr.Filter{Kinds: bookmarkKinds}, 10_000) {
+		if evt.PubKey == relayPubKey {
+			continue
+		}
+		for _, b := range bookmarksIn(evt) {
+			tag := nostr.Tag{"r", b.URL}
+			if b.Reference != "" {
+				tag = nostr.Tag{"e", b.Reference}
+				if strings.Contains(b.Reference, ":") {
+					tag[0] = "a"
+				}
+			}
+			if !slices.ContainsFunc(set.Tags, func(t nostr.Tag) bool { return t[0] == tag[0] && t[1] == tag[1] }) {
+				set.Tags = append(set.Tags, tag)
+			}
+		}
+	}
+
+	if err := set.Sign(global.Settings.RelayInternalSecretKey); err != nil {
+		return
+	}
+	if _, err := tr.db.ReplaceEvent(set); err != nil {
+		log.Warn().Err(err).Str("team", id).Msg("failed to save team bookmark set")
+		return
+	}
+	tr.relay.BroadcastEvent(set)
+}
+
+// teamID makes a sub-path out of a collection name that isn't used by another collection yet.
+func teamID(name string) string {
+	base := strings.Trim(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, name), "-")
+	if base == "" {
+		base = "team"
+	}
+
+	id := base
+	for i := 2; ; i++ {
+		if _, exists := findTeam(id); !exists {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+func teamPageHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	tr, ok := teamRelayAt(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	team, ok := findTeam(tr.id)
+	if !ok || !canAccessTeam(team, loggedUser) {
+		http.Error(w, "this collection is only for its team", 403)
+		return
+	}
+
+	view := searchView{
+		BaseURL: global.Settings.Bookmarks.GetPageURL() + "team/" + team.ID,
+		Query:   r.URL.Query().Get("q"),
+		Tag:     r.URL.Query().Get("tag"),
+		Folder:  r.URL.Query().Get("folder"),
+	}
+	all := userBookmarks(tr.db)
+	view.Tags, view.Folders = facets(all)
+	view.Bookmarks = filterBookmarks(all, view.Query, view.Tag, view.Folder)
+
+	teamPage(loggedUser, team, view).Render(r.Context(), w)
+}
+
+// teamsHandler creates a team collection or changes who has access to one.
+func teamsHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsMember(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	members := make([]nostr.PubKey, 0, 10)
+	for _, line := range strings.FieldsFunc(r.PostFormValue("members"), func(r rune) bool { return r == '\n' || r == ',' || r == ' ' }) {
+		if pk := global.PubKeyFromInput(strings.TrimSpace(line)); pk != nostr.ZeroPK && !slices.Contains(members, pk) {
+			members = append(members, pk)
+		}
+	}
+	role := r.PostFormValue("role")
+	if role != "" {
+		if _, ok := pyramid.Roles.Load(role); !ok {
+			http.Error(w, "unknown role", 400)
+			return
+		}
+	}
+
+	if id := r.PostFormValue("id"); id != "" {
+		idx := slices.IndexFunc(global.Settings.Bookmarks.Teams, func(t global.TeamCollection) bool { return t.ID == id })
+		if idx == -1 {
+			http.Error(w, "collection not found", 404)
+			return
+		}
+		if !canManageTeam(global.Settings.Bookmarks.Teams[idx], loggedUser) {
+			http.Error(w, "only the owner of a collection can change it", 403)
+			return
+		}
+		global.Settings.Bookmarks.Teams[idx].Members = members
+		global.Settings.Bookmarks.Teams[idx].Role = role
+	} else {
+		name := strings.TrimSpace(r.PostFormValue("name"))
+		if name == "" {
+			http.Error(w, "a collection needs a name", 400)
+			return
+		}
+		team := global.TeamCollection{
+			ID:      teamID(name),
+			Name:    name,
+			Owner:   loggedUser,
+			Members: members,
+			Role:    role,
+		}
+		global.Settings.Bookmarks.Teams = append(global.Settings.Bookmarks.Teams, team)
+		if err := setupTeam(team); err != nil {
+			global.Settings.Bookmarks.Teams = global.Settings.Bookmarks.Teams[0 : len(global.Settings.Bookmarks.Teams)-1]
+			http.Error(w, "failed to create collection: "+err.Error(), 500)
+			return
+		}
+		log.Info().Str("team", team.ID).Str("owner", loggedUser.Hex()).Msg("team collection created")
+	}
+
+	if err := global.SaveUserSettings(); err != nil {
+		http.Error(w, "failed to save settings: "+err.Error(), 500)
+		return
+	}
+	http.Redirect(w, r, global.Settings.Bookmarks.GetPageURL(), 302)
+}
+
+func deleteTeamHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+
+	id := r.PostFormValue("id")
+	team, ok := findTeam(id)
+	if !ok {
+		http.Error(w, "collection not found", 404)
+		return
+	}
+	if !canManageTeam(team, loggedUser) {
+		http.Error(w, "only the owner of a collection can delete it", 403)
+		return
+	}
+
+	global.Settings.Bookmarks.Teams = slices.DeleteFunc(global.Settings.Bookmarks.Teams, func(t global.TeamCollection) bool { return t.ID == id })
+	if err := global.SaveUserSettings(); err != nil {
+		http.Error(w, "failed to save settings: "+err.Error(), 500)
+		return
+	}
+
+	teamRelays.Delete(id)
+	if err := global.MMMM.DropLayer(teamLayerName(id)); err != nil {
+		log.Warn().Err(err).Str("team", id).Msg("failed to drop team collection layer")
+	}
+	log.Info().Str("team", id).Str("caller", loggedUser.Hex()).Msg("team collection deleted")
+	http.Redirect(w, r, global.Settings.Bookmarks.GetPageURL(), 302)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip19"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+type bulkEntryJSON struct {
+	PubKey string `json:"pubkey"`
+	Parent string `json:"parent"` // "root" for root members
+}
+
+type bulkResultJSON struct {
+	Invites   []bulkEntryJSON `json:"invites"`
+	Unchanged []bulkEntryJSON `json:"unchanged"`
+	Rejected  []string        `json:"rejected"`
+	Applied   int             `json:"applied"`
+	DryRun    bool            `json:"dry_run"`
+}
+
+func toBulkEntriesJSON(entries []pyramid.BulkEntry) []bulkEntryJSON {
+	list := make([]bulkEntryJSON, len(entries))
+	for i, entry := range entries {
+		list[i] = bulkEntryJSON{PubKey: nip19.EncodeNpub(entry.PubKey), Parent: "root"}
+		if entry.Parent != pyramid.AbsoluteKey {
+			list[i].Parent = nip19.EncodeNpub(entry.Parent)
+		}
+	}
+	return list
+}
+
+// importMembers parses input (a csv or a list event), checks it against the tree and, unless dryRun is set, applies it.
+// defaultParent is used for entries that don't specify one, an empty value means root.
+func importMembers(caller nostr.PubKey, input string, defaultParent string, dryRun bool) (bulkResultJSON, error) {
+	if !pyramid.IsRoot(caller) {
+		return bulkResultJSON{}, fmt.Errorf("only root members can import members")
+	}
+
+	parent := pyramid.AbsoluteKey
+	if defaultParent = strings.TrimSpace(defaultParent); defaultParent != "" && defaultParent != "root" {
+		if parent = global.PubKeyFromInput(defaultParent); parent == nostr.ZeroPK {
+			return bulkResultJSON{}, fmt.Errorf("invalid default parent")
+		}
+	}
+
+	entries, rejected := pyramid.ParseMembersImport(input, parent)
+	plan := pyramid.PlanBulkImport(entries)
+	result := bulkResultJSON{
+		Invites:   toBulkEntriesJSON(plan.Invites),
+		Unchanged: toBulkEntriesJSON(plan.Unchanged),
+		Rejected:  append(append([]string{}, rejected...), plan.Rejected...),
+		DryRun:    dryRun,
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	log.Info().Str("caller", caller.Hex()).Int("invites", len(plan.Invites)).Msg("bulk member import")
+	applied, err := pyramid.ApplyBulkImport(caller, plan)
+	result.Applied = applied
+	for _, entry := range plan.Invites[:applied] {
+		go publishMembershipChange(entry.PubKey, true)
+	}
+	return result, err
+}
+
+func membersImportHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsRoot(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	input := r.FormValue("input")
+	if file, _, err := r.FormFile("file"); err == nil {
+		data, err := io.ReadAll(io.LimitReader(file, 10_000_000))
+		file.Close()
+		if err != nil {
+			http.Error(w, "failed to read file: "+err.Error(), 400)
+			return
+		}
+		input = string(data)
+	}
+
+	result, err := importMembers(loggedUser, input, r.FormValue("parent"), r.FormValue("dry_run") != "")
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func membersExportHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsMember(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="members.csv"`)
+	if err := pyramid.WriteMembersCSV(w, pyramid.ExportMembers()); err != nil {
+		log.Error().Err(err).Msg("failed to export members")
+	}
+}
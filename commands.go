@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip11"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+// commands are the first arguments that make pyramid run a command instead of starting the relay,
+// anything else is ignored so wrappers and restarts can pass whatever they want.
+var commands = []string{"export-management", "verify-management", "bootstrap"}
+
+// runCommand handles the things that can be done from the command line without starting the relay.
+// it returns the exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "export-management":
+		// pyramid export-management [file]
+		if err := global.InitConfig(); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't initialize: %s\n", err)
+			return 7
+		}
+
+		var out io.Writer = os.Stdout
+		if len(args) > 1 {
+			file, err := os.Create(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "couldn't create %s: %s\n", args[1], err)
+				return 1
+			}
+			defer file.Close()
+			out = file
+		}
+
+		// check our own log before handing it out
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(pyramid.ExportActionLog(pw))
+		}()
+		count, last, err := pyramid.VerifyActionLog(io.TeeReader(pr, out), global.Settings.RelayInternalSecretKey.Public())
+		if err != nil {
+			// so the exporting goroutine stops writing and exits
+			pr.CloseWithError(err)
+			fmt.Fprintf(os.Stderr, "management log is broken: %s\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "exported %d actions, last is %s\n", count, last.Hex())
+		return 0
+
+	case "verify-management":
+		// pyramid verify-management <file or url> <relay pubkey>
+		if len(args) < 3 {
+			fmt.Fprintf(os.Stderr, "usage: pyramid verify-management <file or url> <relay pubkey>\n")
+			return 2
+		}
+
+		signer := global.PubKeyFromInput(args[2])
+		if signer == nostr.ZeroPK {
+			fmt.Fprintf(os.Stderr, "invalid relay pubkey '%s'\n", args[2])
+			return 2
+		}
+
+		var in io.Reader
+		if strings.HasPrefix(args[1], "http://") || strings.HasPrefix(args[1], "https://") {
+			resp, err := http.Get(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "couldn't fetch %s: %s\n", args[1], err)
+				return 1
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				fmt.Fprintf(os.Stderr, "couldn't fetch %s: status %d\n", args[1], resp.StatusCode)
+				return 1
+			}
+			in = resp.Body
+		} else {
+			file, err := os.Open(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "couldn't open %s: %s\n", args[1], err)
+				return 1
+			}
+			defer file.Close()
+			in = file
+		}
+
+		count, last, err := pyramid.VerifyActionLog(in, signer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid management log: %s\n", err)
+			return 1
+		}
+		fmt.Printf("%d actions verified, last is %s\n", count, last.Hex())
+		return 0
+
+	case "bootstrap":
+		// pyramid bootstrap [-force] [-flat] <remote pyramid url> <root pubkey>
+		var force, flat bool
+		for len(args) > 1 && strings.HasPrefix(args[1], "-") {
+			switch args[1] {
+			case "-force":
+				force = true
+			case "-flat":
+				flat = true
+			default:
+				fmt.Fprintf(os.Stderr, "unknown flag '%s'\n", args[1])
+				return 2
+			}
+			args = args[1:]
+		}
+		if len(args) < 3 {
+			fmt.Fprintf(os.Stderr, "usage: pyramid bootstrap [-force] [-flat] <remote pyramid url> <root pubkey>\n")
+			return 2
+		}
+
+		root := global.PubKeyFromInput(args[2])
+		if root == nostr.ZeroPK {
+			fmt.Fprintf(os.Stderr, "invalid root pubkey '%s'\n", args[2])
+			return 2
+		}
+
+		if err := global.InitConfig(); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't initialize: %s\n", err)
+			return 7
+		}
+		pyramid.AbsoluteKey = global.Settings.RelayInternalSecretKey.Public()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
+		defer cancel()
+
+		count, err := bootstrapMembership(ctx, args[1], root, force, flat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bootstrap failed: %s\n", err)
+			return 1
+		}
+		fmt.Printf("wrote %d actions, %d members\n", count, pyramid.Members.Size())
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%s', available commands are: %s\n", args[0], strings.Join(commands, ", "))
+		return 2
+	}
+}
+
+// bootstrapMembership writes a local management log equivalent to the state of another pyramid.
+// the remote signed log is used when available since it has the entire tree, otherwise
+// we make do with the published member list and role definitions, but only when flat is given
+// since that loses who invited whom and brings back removed members that are still listed.
+// with replace an existing log is swapped for the new one once it has been fully written.
+func bootstrapMembership(ctx context.Context, remoteURL string, root nostr.PubKey, replace bool, flat bool) (int, error) {
+	remoteURL = nostr.NormalizeURL(remoteURL)
+
+	info, err := nip11.Fetch(ctx, remoteURL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch relay information: %w", err)
+	}
+	if info.Self == nil {
+		return 0, fmt.Errorf("remote relay doesn't tell its own pubkey")
+	}
+	remoteKey := *info.Self
+
+	logURL := "http" + strings.TrimPrefix(remoteURL, "ws") + "/management.jsonl"
+	req, _ := http.NewRequestWithContext(ctx, "GET", logURL, nil)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode == 200 {
+			return pyramid.ImportRemoteLog(resp.Body, remoteKey, root, replace)
+		}
+	}
+
+	if !flat {
+		return 0, fmt.Errorf("%s not available, pass -flat to rebuild from the published member list instead"+
+			" (everybody becomes a direct child of root and removed members that are still listed are enabled again)", logURL)
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: %s not available, using the published member list:"+
+		" every member will be a direct child of root and removed members that are still listed will be enabled again\n", logURL)
+
+	pool := nostr.NewPool()
+	defer pool.Close("done")
+
+	var memberList *nostr.Event
+	var roleDefinitions []nostr.Event
+	for ie := range pool.FetchMany(ctx, []string{remoteURL}, nostr.Filter{
+		Kinds:   []nostr.Kind{13534, 33534},
+		Authors: []nostr.PubKey{remoteKey},
+	}, nostr.SubscriptionOptions{}) {
+		if !ie.Event.VerifySignature() {
+			continue
+		}
+		switch ie.Event.Kind {
+		case 13534:
+			if memberList == nil || memberList.CreatedAt < ie.Event.CreatedAt {
+				memberList = &ie.Event
+			}
+		case 33534:
+			roleDefinitions = append(roleDefinitions, ie.Event)
+		}
+	}
+	if memberList == nil {
+		return 0, fmt.Errorf("couldn't find a member list published by %s", remoteKey.Hex())
+	}
+	if memberList.Tags.FindWithValue("member", root.Hex()) == nil {
+		return 0, fmt.Errorf("%s isn't a member there", root.Hex())
+	}
+
+	return pyramid.ImportPublishedLists(*memberList, roleDefinitions, root, replace)
+}
+
+func managementLogHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := pyramid.ExportActionLog(w); err != nil {
+		log.Error().Err(err).Msg("failed to export management log")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/khatru"
+	"github.com/puzpuzpuz/xsync/v3"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+// NIP-32 namespace used for the labels that hide events behind a content warning
+const contentWarningNamespace = "content-warning"
+
+// labelledEvents maps the events in main that got a content-warning label (from the relay
+// itself or from a moderator) to the reason given in the label.
+var labelledEvents = xsync.NewMapOf[nostr.ID, string]()
+
+func loadContentWarningLabels() {
+	for label := range global.IL.Main.QueryEvents(nostr.Filter{
+		Kinds: []nostr.Kind{1985},
+		Tags:  nostr.TagMap{"L": []string{contentWarningNamespace}},
+	}, 1_000_000) {
+		registerContentWarningLabel(label)
+	}
+}
+
+func isContentWarningLabel(event nostr.Event) bool {
+	return event.Kind == 1985 && event.Tags.FindWithValue("L", contentWarningNamespace) != nil
+}
+
+// registerContentWarningLabel takes labels only from the relay key, root members and moderators.
+func registerContentWarningLabel(label nostr.Event) {
+	if !isContentWarningLabel(label) {
+		return
+	}
+	if label.PubKey != global.Settings.RelayInternalSecretKey.Public() && !pyramid.HasCapability(label.PubKey, pyramid.CapModerate) {
+		return
+	}
+
+	reason := ""
+	if l := label.Tags.Find("l"); l != nil {
+		reason = l[1]
+	}
+	for tag := range label.Tags.FindAll("e") {
+		if id, err := nostr.IDFromHex(tag[1]); err == nil {
+			labelledEvents.Store(id, reason)
+		}
+	}
+}
+
+func unregisterContentWarningLabel(label nostr.Event) {
+	if !isContentWarningLabel(label) {
+		return
+	}
+	for tag := range label.Tags.FindAll("e") {
+		if id, err := nostr.IDFromHex(tag[1]); err == nil {
+			labelledEvents.Delete(id)
+		}
+	}
+}
+
+// missingContentWarning tells if the policy requires a NIP-36 content-warning tag on this event and it doesn't have one.
+func missingContentWarning(event nostr.Event) (bool, string) {
+	policy := global.Settings.ContentWarnings
+	if event.Tags.Find("content-warning") != nil {
+		return false, ""
+	}
+
+	if slices.Contains(policy.RequiredOnKinds, event.Kind) {
+		return true, fmt.Sprintf("blocked: kind %d requires a content-warning tag", event.Kind)
+	}
+	for tag := range event.Tags.FindAll("t") {
+		if slices.Contains(policy.RequiredOnHashtags, strings.ToLower(tag[1])) {
+			return true, fmt.Sprintf("blocked: #%s requires a content-warning tag", tag[1])
+		}
+	}
+	return false, ""
+}
+
+// labelReportedEvent publishes a content-warning label for an event that was reported by enough members,
+// carrying the most common report type.
+func labelReportedEvent(target nostr.Event, reporters int, reasons map[string]int) {
+	if _, labelled := labelledEvents.Load(target.ID); labelled {
+		return
+	}
+
+	reason := "reported"
+	for r, count := range reasons {
+		if count > reasons[reason] || (count == reasons[reason] && r < reason) {
+			reason = r
+		}
+	}
+
+	label := nostr.Event{
+		Kind:      1985,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"L", contentWarningNamespace},
+			{"l", reason, contentWarningNamespace},
+			{"e", target.ID.Hex()},
+			{"p", target.PubKey.Hex()},
+		},
+		Content: fmt.Sprintf("reported by %d members", reporters),
+	}
+	label.Sign(global.Settings.RelayInternalSecretKey)
+	if err := global.IL.Main.SaveEvent(label); err != nil {
+		log.Error().Err(err).Msg("failed to save content-warning label")
+		return
+	}
+	relay.BroadcastEvent(label)
+	registerContentWarningLabel(label)
+	log.Info().Str("event", target.ID.Hex()).Str("reason", reason).Msg("event labelled with a content warning")
+}
+
+// removeRelayLabels deletes the content-warning labels the relay itself added to an event.
+func removeRelayLabels(id nostr.ID) {
+	labels := slices.Collect(global.IL.Main.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{1985},
+		Authors: []nostr.PubKey{global.Settings.RelayInternalSecretKey.Public()},
+		Tags:    nostr.TagMap{"L": []string{contentWarningNamespace}, "e": []string{id.Hex()}},
+	}, 10))
+	for _, label := range labels {
+		if err := global.IL.Main.DeleteEvent(label.ID); err != nil {
+			log.Warn().Err(err).Str("label", label.ID.Hex()).Msg("failed to delete content-warning label")
+			continue
+		}
+		unregisterContentWarningLabel(label)
+	}
+}
+
+// hideLabelledEvents removes labelled events from a query when the reader isn't authenticated.
+func hideLabelledEvents(ctx context.Context, query iter.Seq[nostr.Event]) iter.Seq[nostr.Event] {
+	if !global.Settings.ContentWarnings.HideFromUnauthenticated || len(khatru.GetAllAuthed(ctx)) > 0 {
+		return query
+	}
+
+	return func(yield func(nostr.Event) bool) {
+		for evt := range query {
+			if _, labelled := labelledEvents.Load(evt.ID); labelled {
+				continue
+			}
+			if !yield(evt) {
+				return
+			}
+		}
+	}
+}
@@ -52,6 +52,11 @@ func basicRejectionLogic(ctx context.Context, event nostr.Event) (reject bool, m
 		}
 	}
 
+	// this goes before everything that is accepted early below, suspended members can only leave
+	if event.Kind != 28936 && pyramid.IsSuspended(event.PubKey, pyramid.ScopePublish) {
+		return true, "restricted: you are suspended from publishing here"
+	}
+
 	// if a member has referenced a list on his paywall settings we'll accept that
 	if global.Settings.Paywall.Enabled {
 		if event.Kind.IsReplaceable() || event.Kind.IsAddressable() {
@@ -66,6 +71,8 @@ func basicRejectionLogic(ctx context.Context, event nostr.Event) (reject bool, m
 		// allow 1163 if paywall is enabled
 	} else if event.Kind == 28934 || event.Kind == 28936 {
 		// these are always allowed
+	} else if isContentWarningLabel(event) && pyramid.HasCapability(event.PubKey, pyramid.CapModerate) {
+		// moderators can always label events with content warnings
 	} else if !global.KindIsAllowed(event.Kind) {
 		return true, "blocked: kind unallowed"
 	}
@@ -188,6 +195,10 @@ func basicRejectionLogic(ctx context.Context, event nostr.Event) (reject bool, m
 		return false, "goodbye"
 	}
 
+	if missing, msg := missingContentWarning(event); missing {
+		return true, msg
+	}
+
 	// we accept stuff from non-members in this case
 	if global.Settings.AllowEphemeralFromAnyone && event.Kind.IsEphemeral() {
 		return false, ""
@@ -252,13 +263,21 @@ func preventBroadcast(ws *khatru.WebSocket, filter nostr.Filter, event nostr.Eve
 		if nip70.IsProtected(event) && event.Tags.Has("nip63") {
 			// this is a paywalled event, check if reader can read
 			for _, pk := range ws.AuthedPublicKeys {
-				if paywall.CanRead(event.PubKey, pk) {
+				if paywall.CanReadEvent(event, pk) {
 					// if they can read we're fine broadcasting this
 					return false
 				}
 			}
 			// couldn't find any authenticated user that can read this, so do not broadcast
+			// (they get the teaser, which is broadcast separately)
 			return true
+		} else if event.Tags.Has("nip63-teaser") {
+			// readers that can see the full event don't need its teaser
+			for _, pk := range ws.AuthedPublicKeys {
+				if paywall.TeaserIsRedundant(event, pk) {
+					return true
+				}
+			}
 		} else {
 			// not paywalled, anyone can read
 		}
@@ -487,6 +506,9 @@ func replaceOnMain(event nostr.Event) ([]nostr.Event, error) {
 	if err != nil {
 		return replaced, err
 	}
+	for _, previous := range replaced {
+		paywall.ForgetTeaser(previous.ID)
+	}
 
 	if global.Settings.Search.Enable {
 		return replaced, search.Main.SaveEvent(event)
@@ -566,6 +588,8 @@ func queryStored(ctx context.Context, filter nostr.Filter) iter.Seq[nostr.Event]
 		// normal query
 		query := global.IL.Main.QueryEvents(filter, global.Settings.Limits.MaxQueryLimit)
 		query = groups.FilterQuery(ctx, filter, query)
+		query = hideLabelledEvents(ctx, query)
+		query = hideReportedEvents(ctx, query)
 
 		if global.Settings.Paywall.Enabled {
 			// use this special query that filters content for paying visitors
@@ -574,12 +598,14 @@ func queryStored(ctx context.Context, filter nostr.Filter) iter.Seq[nostr.Event]
 			for evt := range query {
 				if evt.Tags.Has("nip63") {
 					// this is a paywalled event, check if reader can read
-					for _, pk := range authed {
-						if paywall.CanRead(evt.PubKey, pk) {
-							if !yield(evt) {
-								return
-							}
-							break
+					if slices.ContainsFunc(authed, func(pk nostr.PubKey) bool { return paywall.CanReadEvent(evt, pk) }) {
+						if !yield(evt) {
+							return
+						}
+					} else if teaser, ok := paywall.Teaser(evt); ok {
+						// otherwise they get a preview
+						if !yield(teaser) {
+							return
 						}
 					}
 				} else if evt.Kind == 1163 {
@@ -612,11 +638,21 @@ func handleDeleted(ctx context.Context, deleted nostr.Event) {
 		log.Error().Err(err).Stringer("event", deleted).Msg("failed to delete event from group search index")
 	}
 
+	if deleted.Kind == 1985 {
+		unregisterContentWarningLabel(deleted)
+		return
+	}
+
 	if deleted.Kind == 1163 {
 		paywall.RecomputeMemberPaywall(ctx, deleted.PubKey)
 		return
 	}
 
+	if deleted.Tags.Has("nip63") {
+		paywall.ForgetTeaser(deleted.ID)
+		paywall.ForgetPaywalledEvents(deleted.PubKey)
+	}
+
 	if deleted.Kind.IsReplaceable() || deleted.Kind.IsAddressable() {
 		for by := range paywall.ReferencedBy(deleted) {
 			paywall.RecomputeMemberPaywall(ctx, by)
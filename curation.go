@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"iter"
+	"slices"
 	"unsafe"
 
 	"fiatjaf.com/nostr"
 	"fiatjaf.com/nostr/eventstore"
+	"fiatjaf.com/nostr/eventstore/mmm"
 	"fiatjaf.com/nostr/nip27"
 	"fiatjaf.com/nostr/nip70"
 	"fiatjaf.com/nostr/sdk"
@@ -15,7 +17,7 @@ import (
 	"github.com/mailru/easyjson"
 )
 
-var reactionKinds = []nostr.Kind{6, 7, 9321, 9735, 9802, 1, 1111, 1244}
+var reactionKinds = []nostr.Kind{6, 7, 16, 9321, 9735, 9802, 1, 1111, 1244}
 
 func processReactions(ctx context.Context, event nostr.Event) {
 	totalMembers := pyramid.Members.Size()
@@ -24,56 +26,29 @@ func processReactions(ctx context.Context, event nostr.Event) {
 		return
 	}
 
-	// sum existing reactions for this target, a unique vote per member
-	popularVotes := make(map[string]map[nostr.PubKey]struct{})
-	bestVotes := make(map[string]map[nostr.PubKey]struct{})
-
-	for val, tagNames := range getTargets(event) {
-		for _, tagName := range tagNames {
-			for reaction := range global.IL.Main.QueryEvents(nostr.Filter{
-				Since: nostr.Now() - 60*60*24*7, /* a week ago */
-				Kinds: reactionKinds,
-				Tags:  nostr.TagMap{tagName: []string{val}},
-			}, 1000) {
-				for target := range getTargets(reaction) {
-					if votes, ok := popularVotes[target]; ok {
-						votes[reaction.PubKey] = struct{}{}
-					} else {
-						popularVotes[target] = map[nostr.PubKey]struct{}{reaction.PubKey: {}}
-					}
-
-					if reaction.Kind != 1 && reaction.Kind != 1111 && reaction.Kind != 1244 {
-						if votes, ok := bestVotes[target]; ok {
-							votes[reaction.PubKey] = struct{}{}
-						} else {
-							bestVotes[target] = map[nostr.PubKey]struct{}{reaction.PubKey: {}}
-						}
-					}
-				}
-			}
-		}
-	}
-
-	popularThreshold := max(2, (totalMembers*global.Settings.Popular.PercentThreshold)/100)
-	uppermostThreshold := max(3, (totalMembers*global.Settings.Uppermost.PercentThreshold)/100)
+	now := nostr.Now()
+	tallies := tallyVotes(getTargets(event), now-nostr.Timestamp(max(1, global.Settings.Curation.WindowDays)*60*60*24), now)
+	popularThreshold, uppermostThreshold := curationThresholds()
 
 	// for all events we meet the popular threshold for
-	for target, votes := range popularVotes {
-		if len(votes) < popularThreshold {
+	for target, tally := range tallies {
+		popularScore := tally.score(anyVote)
+		if popularScore.Total < popularThreshold {
 			continue
 		}
 
 		// fetch
 		targetEvent := fetchEventBasedOnHintsWeHave(target)
 		if targetEvent == nil {
-			return
+			continue
 		}
 		if nip70.IsProtected(*targetEvent) || nip70.HasEmbeddedProtected(*targetEvent) {
-			return
+			continue
 		}
 
 		// add to the qualified layers
-		if votes, ok := bestVotes[target]; ok && len(votes) >= uppermostThreshold {
+		if uppermostScore := tally.score(uppermostVote); uppermostScore.Total >= uppermostThreshold {
+			wasThere := isCurated(global.IL.Uppermost, targetEvent.ID)
 			var err error
 			if targetEvent.Kind.IsAddressable() || targetEvent.Kind.IsReplaceable() {
 				_, err = global.IL.Uppermost.ReplaceEvent(*targetEvent)
@@ -83,6 +58,11 @@ func processReactions(ctx context.Context, event nostr.Event) {
 			if err != nil && err != eventstore.ErrDupEvent {
 				log.Warn().Err(err).Msg("failed to save to uppermost layer")
 			} else {
+				if !wasThere {
+					recordCuration(global.RelayUppermost, global.CurationPromoted, *targetEvent, uppermostScore, uppermostThreshold,
+						uppermostScore.explain(uppermostThreshold))
+				}
+
 				// if promoted to uppermost, delete from popular
 				if err := global.IL.Popular.DeleteEvent(targetEvent.ID); err != nil {
 					log.Warn().Err(err).Msg("failed to remove from popular layer after uppermost promotion")
@@ -90,6 +70,7 @@ func processReactions(ctx context.Context, event nostr.Event) {
 			}
 		} else {
 			// only save to popular if not saved to uppermost
+			wasThere := isCurated(global.IL.Popular, targetEvent.ID)
 			var err error
 			if targetEvent.Kind.IsAddressable() || targetEvent.Kind.IsReplaceable() {
 				_, err = global.IL.Popular.ReplaceEvent(*targetEvent)
@@ -98,9 +79,71 @@ func processReactions(ctx context.Context, event nostr.Event) {
 			}
 			if err != nil && err != eventstore.ErrDupEvent {
 				log.Warn().Err(err).Msg("failed to save to popular layer")
+			} else if !wasThere {
+				recordCuration(global.RelayPopular, global.CurationPromoted, *targetEvent, popularScore, popularThreshold,
+					popularScore.explain(popularThreshold))
+			}
+		}
+	}
+}
+
+// tallyVotes collects the votes on each of the given targets and on anything else the same reactions point to.
+func tallyVotes(targets iter.Seq2[string, []string], since nostr.Timestamp, now nostr.Timestamp) map[string]curationTally {
+	tallies := make(map[string]curationTally)
+
+	for val, tagNames := range targets {
+		for _, tagName := range tagNames {
+			for reaction := range global.IL.Main.QueryEvents(nostr.Filter{
+				Since: since,
+				Kinds: reactionKinds,
+				Tags:  nostr.TagMap{tagName: []string{val}},
+			}, 1000) {
+				voter, vote, ok := castVote(reaction, now)
+				if !ok {
+					continue
+				}
+				for target := range getTargets(reaction) {
+					tally, ok := tallies[target]
+					if !ok {
+						tally = make(curationTally)
+						tallies[target] = tally
+					}
+					if !slices.Contains(tally[voter], vote) {
+						tally[voter] = append(tally[voter], vote)
+					}
+				}
 			}
 		}
 	}
+
+	return tallies
+}
+
+// curationThresholds are the scores events need to get into popular and uppermost.
+func curationThresholds() (popular float64, uppermost float64) {
+	totalMembers := pyramid.Members.Size()
+	return max(2, float64(totalMembers*global.Settings.Popular.PercentThreshold)/100),
+		max(3, float64(totalMembers*global.Settings.Uppermost.PercentThreshold)/100)
+}
+
+func isCurated(layer *mmm.IndexingLayer, id nostr.ID) bool {
+	for range layer.QueryEvents(nostr.Filter{IDs: []nostr.ID{id}}, 1) {
+		return true
+	}
+	return false
+}
+
+// zapRequest extracts the zap request from the "description" of a zap receipt.
+func zapRequest(receipt nostr.Event) (nostr.Event, bool) {
+	var request nostr.Event
+	desc := receipt.Tags.Find("description")
+	if desc == nil {
+		return request, false
+	}
+	if err := easyjson.Unmarshal(unsafe.Slice(unsafe.StringData(desc[1]), len(desc[1])), &request); err != nil {
+		return request, false
+	}
+	return request, true
 }
 
 // emits a tuple of (either an id or an address, ["a", "q"] or ["e", "q"])
@@ -108,18 +151,11 @@ func getTargets(reaction nostr.Event) iter.Seq2[string, []string] {
 	return func(yield func(string, []string) bool) {
 		// for zaps consider the zap request
 		if reaction.Kind == 9735 {
-			if desc := reaction.Tags.Find("description"); desc != nil {
-				if err := easyjson.Unmarshal(unsafe.Slice(unsafe.StringData(desc[1]), len(desc[1])), &reaction); err != nil {
-					return
-				}
-			} else {
+			request, ok := zapRequest(reaction)
+			if !ok {
 				return
 			}
-		}
-
-		// ignore reactions that are obviously negative
-		if reaction.Content == "⚠️" || reaction.Content == "-" {
-			return
+			reaction = request
 		}
 
 		if eTag := reaction.Tags.Find("e"); eTag != nil {
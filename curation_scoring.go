@@ -0,0 +1,216 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip57"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+// categories of votes, each with its own weight in global.Settings.Curation
+const (
+	voteReaction  = "reaction"
+	voteEmoji     = "emoji"
+	voteRepost    = "repost"
+	voteReply     = "reply"
+	voteHighlight = "highlight"
+	voteZap       = "zap"
+	voteNegative  = "negative"
+)
+
+type curationVote struct {
+	category string
+	weight   float64 // after decay and level adjustments, negative for negative votes
+	sats     uint64
+}
+
+// curationTally has all the votes each voter cast on one target.
+type curationTally map[nostr.PubKey][]curationVote
+
+type categoryScore struct {
+	Count  int
+	Points float64
+	Sats   uint64
+}
+
+// curationScore is the result of scoring a target, with the breakdown of where the points came from.
+type curationScore struct {
+	Total      float64
+	Voters     int
+	ByCategory map[string]*categoryScore
+}
+
+// isNegativeReaction tells if a reaction content means the voter disliked the target.
+func isNegativeReaction(content string) bool {
+	return content == "-" || content == "⚠️" || content == "👎"
+}
+
+// castVote computes who voted, in which category and with how much weight from a reaction event.
+func castVote(reaction nostr.Event, now nostr.Timestamp) (nostr.PubKey, curationVote, bool) {
+	cfg := global.Settings.Curation
+	voter := reaction.PubKey
+	var vote curationVote
+
+	switch reaction.Kind {
+	case 7:
+		switch {
+		case isNegativeReaction(reaction.Content):
+			vote = curationVote{category: voteNegative, weight: -cfg.NegativeWeight}
+		case reaction.Content == "+" || reaction.Content == "":
+			vote = curationVote{category: voteReaction, weight: cfg.ReactionWeight}
+		default:
+			vote = curationVote{category: voteEmoji, weight: cfg.EmojiWeight}
+		}
+	case 6, 16:
+		vote = curationVote{category: voteRepost, weight: cfg.RepostWeight}
+	case 1, 1111, 1244:
+		vote = curationVote{category: voteReply, weight: cfg.ReplyWeight}
+	case 9802:
+		vote = curationVote{category: voteHighlight, weight: cfg.HighlightWeight}
+	case 9735:
+		// the zapper is credited, but only if they really signed the request and are a member,
+		// otherwise anyone could post receipts with made-up requests and mint voters
+		request, ok := zapRequest(reaction)
+		if !ok || !request.VerifySignature() || !pyramid.IsMember(request.PubKey) {
+			return voter, vote, false
+		}
+		voter = request.PubKey
+		vote = curationVote{category: voteZap, weight: cfg.ZapWeight, sats: nip57.GetAmountFromZap(reaction) / 1000}
+	case 9321:
+		vote = curationVote{category: voteZap, weight: cfg.ZapWeight}
+		for proofTag := range reaction.Tags.FindAll("proof") {
+			var proof struct {
+				Amount uint64 `json:"amount"`
+			}
+			if err := json.Unmarshal([]byte(proofTag[1]), &proof); err == nil {
+				vote.sats += proof.Amount
+			}
+		}
+	default:
+		return voter, vote, false
+	}
+
+	if vote.category == voteZap && cfg.ZapSatsPerPoint > 0 {
+		vote.weight += float64(vote.sats) / float64(cfg.ZapSatsPerPoint)
+	}
+
+	// older votes weigh less
+	if cfg.HalfLifeHours > 0 && reaction.CreatedAt < now {
+		hours := float64(now-reaction.CreatedAt) / 3600
+		vote.weight *= math.Pow(0.5, hours/cfg.HalfLifeHours)
+	}
+
+	// members at different levels of the tree may weigh differently
+	if level := pyramid.GetLevel(voter); level >= 0 && level != math.MaxInt {
+		vote.weight *= max(0, 1+cfg.LevelWeight*float64(level))
+	}
+
+	return voter, vote, vote.weight != 0
+}
+
+// score sums the votes in the categories accepted by include. each voter counts once:
+// with their heaviest positive vote or, if they only disliked the target, with their negative vote.
+func (tally curationTally) score(include func(category string) bool) curationScore {
+	result := curationScore{ByCategory: make(map[string]*categoryScore)}
+	maxPerMember := global.Settings.Curation.MaxPerMember
+
+	for _, votes := range tally {
+		var chosen *curationVote
+		for i, vote := range votes {
+			if !include(vote.category) {
+				continue
+			}
+			if chosen == nil ||
+				(vote.weight > 0 && vote.weight > chosen.weight) ||
+				(vote.weight < 0 && chosen.weight < 0 && vote.weight < chosen.weight) {
+				chosen = &votes[i]
+			}
+		}
+		if chosen == nil {
+			continue
+		}
+
+		points := chosen.weight
+		if maxPerMember > 0 {
+			points = min(points, maxPerMember)
+		}
+
+		result.Total += points
+		result.Voters++
+		cs, ok := result.ByCategory[chosen.category]
+		if !ok {
+			cs = &categoryScore{}
+			result.ByCategory[chosen.category] = cs
+		}
+		cs.Count++
+		cs.Points += points
+		cs.Sats += chosen.sats
+	}
+
+	return result
+}
+
+// explain describes how a score was reached, to be shown in the curated relay pages.
+func (cs curationScore) explain(threshold float64) string {
+	categories := make([]string, 0, len(cs.ByCategory))
+	for category := range cs.ByCategory {
+		categories = append(categories, category)
+	}
+	slices.SortFunc(categories, func(a, b string) int {
+		if c := cmp.Compare(cs.ByCategory[b].Points, cs.ByCategory[a].Points); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+
+	parts := make([]string, 0, len(categories))
+	for _, category := range categories {
+		s := cs.ByCategory[category]
+		part := fmt.Sprintf("%d %s (%+.1f)", s.Count, category, s.Points)
+		if s.Sats > 0 {
+			part = fmt.Sprintf("%d %s of %d sats (%+.1f)", s.Count, category, s.Sats, s.Points)
+		}
+		parts = append(parts, part)
+	}
+
+	return fmt.Sprintf("scored %.1f from %d voters, needed %.1f: %s", cs.Total, cs.Voters, threshold, strings.Join(parts, ", "))
+}
+
+func anyVote(string) bool { return true }
+
+// replies show something is being talked about, not that it is good, so they don't count for uppermost
+func uppermostVote(category string) bool { return category != voteReply }
+
+// recordCuration saves the reason an event entered or left a curated relay.
+func recordCuration(relayId global.RelayID, action string, target nostr.Event, score curationScore, threshold float64, content string) {
+	record := nostr.Event{
+		Kind:      1985,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"L", global.CurationNamespace},
+			{"l", relayId.String(), global.CurationNamespace},
+			{"e", target.ID.Hex()},
+			{"p", target.PubKey.Hex()},
+			{"k", strconv.Itoa(int(target.Kind))},
+			{"action", action},
+			{"score", fmt.Sprintf("%.2f", score.Total)},
+			{"threshold", fmt.Sprintf("%.2f", threshold)},
+		},
+		Content: content,
+	}
+	if err := record.Sign(global.Settings.RelayInternalSecretKey); err != nil {
+		return
+	}
+	if err := global.IL.Curation.SaveEvent(record); err != nil {
+		log.Warn().Err(err).Msg("failed to record curation")
+	}
+}
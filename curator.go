@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/eventstore/mmm"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+// runCurator periodically scores again the events stored in popular and uppermost,
+// dropping the ones that don't qualify anymore and the oldest ones beyond each relay's limit,
+// and publishes their weekly digests.
+func runCurator() {
+	for {
+		time.Sleep(time.Hour)
+		if pyramid.Members.Size() <= 10 {
+			// processReactions doesn't curate anything in this case either
+			continue
+		}
+		demoteStaleCuratedEvents()
+		trimCuratedRelay(global.RelayUppermost, global.IL.Uppermost, global.Settings.Uppermost.MaxEvents, global.Settings.Uppermost.Pinned)
+		trimCuratedRelay(global.RelayPopular, global.IL.Popular, global.Settings.Popular.MaxEvents, global.Settings.Popular.Pinned)
+		publishDueDigests()
+	}
+}
+
+// rescore tallies all the votes an event ever got, regardless of the voting window.
+func rescore(evt nostr.Event, now nostr.Timestamp) curationTally {
+	keys := map[string][]string{evt.ID.Hex(): {"e", "q"}}
+	if evt.Kind.IsAddressable() || evt.Kind.IsReplaceable() {
+		keys[nostr.EntityPointer{PublicKey: evt.PubKey, Kind: evt.Kind, Identifier: evt.Tags.GetD()}.AsTagReference()] = []string{"a", "q"}
+	}
+
+	tally := make(curationTally)
+	for target, t := range tallyVotes(maps.All(keys), 0, now) {
+		if _, ok := keys[target]; !ok {
+			continue
+		}
+		for voter, votes := range t {
+			for _, vote := range votes {
+				if !slices.Contains(tally[voter], vote) {
+					tally[voter] = append(tally[voter], vote)
+				}
+			}
+		}
+	}
+	return tally
+}
+
+// demoteStaleCuratedEvents checks the events that were curated longer than the retention window ago:
+// uppermost events that don't qualify anymore go down to popular (if they still qualify for that), and
+// popular events that don't qualify anymore are dropped.
+func demoteStaleCuratedEvents() {
+	retention := global.Settings.Curation.RetentionDays
+	if retention <= 0 {
+		return
+	}
+
+	now := nostr.Now()
+	cutoff := now - nostr.Timestamp(retention*60*60*24)
+	popularThreshold, uppermostThreshold := curationThresholds()
+
+	for _, curated := range []struct {
+		relayId global.RelayID
+		layer   *mmm.IndexingLayer
+		pinned  nostr.ID
+	}{
+		{global.RelayUppermost, global.IL.Uppermost, global.Settings.Uppermost.Pinned},
+		{global.RelayPopular, global.IL.Popular, global.Settings.Popular.Pinned},
+	} {
+		for _, evt := range slices.Collect(curated.layer.QueryEvents(nostr.Filter{Until: cutoff}, 100_000)) {
+			if evt.ID == curated.pinned || evt.PubKey == global.Settings.RelayInternalSecretKey.Public() {
+				// pinned events and digests stay
+				continue
+			}
+			curatedAt := evt.CreatedAt
+			if record := global.LatestCurationRecord(curated.relayId, evt.ID); record != nil {
+				curatedAt = record.CreatedAt
+			}
+			if curatedAt > cutoff {
+				continue
+			}
+
+			tally := rescore(evt, now)
+			popularScore := tally.score(anyVote)
+
+			threshold, score := popularThreshold, popularScore
+			if curated.relayId == global.RelayUppermost {
+				threshold, score = uppermostThreshold, tally.score(uppermostVote)
+			}
+			if score.Total >= threshold {
+				continue
+			}
+
+			if err := curated.layer.DeleteEvent(evt.ID); err != nil {
+				log.Warn().Err(err).Str("relay", curated.relayId.String()).Msg("failed to demote curated event")
+				continue
+			}
+			recordCuration(curated.relayId, global.CurationDemoted, evt, score, threshold,
+				"no longer qualifies, "+score.explain(threshold))
+			log.Info().Str("relay", curated.relayId.String()).Str("event", evt.ID.Hex()).Float64("score", score.Total).Msg("curated event demoted")
+
+			// events that leave uppermost may still be popular
+			if curated.relayId == global.RelayUppermost && popularScore.Total >= popularThreshold {
+				var err error
+				if evt.Kind.IsAddressable() || evt.Kind.IsReplaceable() {
+					_, err = global.IL.Popular.ReplaceEvent(evt)
+				} else {
+					err = global.IL.Popular.SaveEvent(evt)
+				}
+				if err == nil {
+					recordCuration(global.RelayPopular, global.CurationPromoted, evt, popularScore, popularThreshold,
+						"moved down from uppermost, "+popularScore.explain(popularThreshold))
+				}
+			}
+		}
+	}
+}
+
+// trimCuratedRelay drops the oldest events from a curated relay so it keeps at most limit events, the pinned one and digests stay.
+func trimCuratedRelay(relayId global.RelayID, layer *mmm.IndexingLayer, limit int, pinned nostr.ID) {
+	if limit <= 0 {
+		return
+	}
+
+	i := 0
+	var excess []nostr.Event
+	for evt := range layer.QueryEvents(nostr.Filter{}, 1_000_000) {
+		if evt.ID == pinned || evt.PubKey == global.Settings.RelayInternalSecretKey.Public() {
+			continue
+		}
+		i++
+		if i > limit {
+			excess = append(excess, evt)
+		}
+	}
+
+	for _, evt := range excess {
+		if err := layer.DeleteEvent(evt.ID); err != nil {
+			log.Warn().Err(err).Str("relay", relayId.String()).Msg("failed to drop old curated event")
+			continue
+		}
+		recordCuration(relayId, global.CurationExpired, evt, curationScore{}, 0,
+			fmt.Sprintf("dropped to keep only the latest %d events", limit))
+	}
+	if len(excess) > 0 {
+		log.Info().Str("relay", relayId.String()).Int("dropped", len(excess)).Msg("trimmed curated relay")
+	}
+}
+
+func curationLogHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsMember(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	filter := nostr.Filter{Kinds: []nostr.Kind{1985}, Tags: nostr.TagMap{}}
+	relayId := r.URL.Query().Get("relay")
+	if relayId == global.RelayPopular.String() || relayId == global.RelayUppermost.String() {
+		filter.Tags["l"] = []string{relayId}
+	}
+	action := r.URL.Query().Get("action")
+
+	records := make([]nostr.Event, 0, 200)
+	for record := range global.IL.Curation.QueryEvents(filter, 5000) {
+		if action != "" {
+			// only single-letter tags are indexed
+			if tag := record.Tags.Find("action"); tag == nil || tag[1] != action {
+				continue
+			}
+		}
+		records = append(records, record)
+		if len(records) == 200 {
+			break
+		}
+	}
+	curationLogPage(loggedUser, records, relayId, action).Render(r.Context(), w)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/eventstore/mmm"
+	"fiatjaf.com/nostr/khatru"
+	"fiatjaf.com/nostr/nip19"
+
+	"github.com/fiatjaf/pyramid/feed"
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/popular"
+	"github.com/fiatjaf/pyramid/uppermost"
+)
+
+const digestInterval = 60 * 60 * 24 * 7
+
+// how each category of votes is called in digests
+var digestCountNames = map[string][2]string{
+	voteReaction:  {"reaction", "reactions"},
+	voteEmoji:     {"emoji reaction", "emoji reactions"},
+	voteRepost:    {"repost", "reposts"},
+	voteReply:     {"reply", "replies"},
+	voteHighlight: {"highlight", "highlights"},
+	voteZap:       {"zap", "zaps"},
+	voteNegative:  {"dislike", "dislikes"},
+}
+
+type digestEntry struct {
+	event  nostr.Event
+	score  float64
+	counts string
+}
+
+// publishDueDigests publishes a digest of the best events of the week in popular and uppermost,
+// for each of them that hasn't had one in the last week.
+func publishDueDigests() {
+	kind := global.Settings.Curation.DigestKind
+	if kind != 30023 && kind != 30004 {
+		return
+	}
+
+	now := nostr.Now()
+	for _, curated := range []struct {
+		relayId  global.RelayID
+		layer    *mmm.IndexingLayer
+		relay    *khatru.Relay
+		metadata global.RelayMetadata
+		enabled  bool
+	}{
+		{global.RelayPopular, global.IL.Popular, popular.Relay, global.Settings.Popular.RelayMetadata, global.Settings.Popular.Enabled},
+		{global.RelayUppermost, global.IL.Uppermost, uppermost.Relay, global.Settings.Uppermost.RelayMetadata, global.Settings.Uppermost.Enabled},
+	} {
+		if !curated.enabled {
+			continue
+		}
+
+		// an hour of tolerance since the curator loop runs hourly
+		if latest := feed.LatestDigest(curated.layer); latest != nil && latest.CreatedAt > now-digestInterval+60*60 {
+			continue
+		}
+
+		entries := digestEntries(curated.relayId, curated.layer, now-digestInterval, now)
+		if len(entries) == 0 {
+			continue
+		}
+
+		digest := buildDigest(kind, curated.metadata, entries, now)
+		if err := digest.Sign(global.Settings.RelayInternalSecretKey); err != nil {
+			continue
+		}
+		if _, err := curated.layer.ReplaceEvent(digest); err != nil {
+			log.Warn().Err(err).Str("relay", curated.relayId.String()).Msg("failed to save digest")
+			continue
+		}
+		curated.relay.BroadcastEvent(digest)
+		log.Info().Str("relay", curated.relayId.String()).Int("entries", len(entries)).Msg("digest published")
+	}
+}
+
+// digestEntries picks the best events curated into a relay since the given time.
+func digestEntries(relayId global.RelayID, layer *mmm.IndexingLayer, since nostr.Timestamp, now nostr.Timestamp) []digestEntry {
+	ids := make([]nostr.ID, 0, 100)
+	for record := range global.IL.Curation.QueryEvents(nostr.Filter{
+		Kinds: []nostr.Kind{1985},
+		Tags:  nostr.TagMap{"l": []string{relayId.String()}},
+		Since: since,
+	}, 1000) {
+		if action := record.Tags.Find("action"); action == nil || action[1] != global.CurationPromoted {
+			continue
+		}
+		if e := record.Tags.Find("e"); e != nil {
+			if id, err := nostr.IDFromHex(e[1]); err == nil && !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	relayPubKey := global.Settings.RelayInternalSecretKey.Public()
+	include := anyVote
+	if relayId == global.RelayUppermost {
+		include = uppermostVote
+	}
+
+	entries := make([]digestEntry, 0, len(ids))
+	add := func(evt nostr.Event) {
+		if evt.PubKey == relayPubKey || slices.ContainsFunc(entries, func(e digestEntry) bool { return e.event.ID == evt.ID }) {
+			return
+		}
+		tally := rescore(evt, now)
+		entries = append(entries, digestEntry{
+			event:  evt,
+			score:  tally.score(include).Total,
+			counts: describeCounts(tally),
+		})
+	}
+
+	if len(ids) > 0 {
+		for evt := range layer.QueryEvents(nostr.Filter{IDs: ids}, len(ids)) {
+			add(evt)
+		}
+	}
+	// events curated before there were curation records
+	for evt := range layer.QueryEvents(nostr.Filter{Since: since}, 1000) {
+		add(evt)
+	}
+
+	slices.SortFunc(entries, func(a, b digestEntry) int { return cmp.Compare(b.score, a.score) })
+	return entries[0:min(len(entries), max(1, global.Settings.Curation.DigestSize))]
+}
+
+// describeCounts says how many votes of each kind an event got, like "12 reactions, 2 zaps (2100 sats)".
+func describeCounts(tally curationTally) string {
+	counts := make(map[string]int)
+	var sats uint64
+	for _, votes := range tally {
+		for _, vote := range votes {
+			counts[vote.category]++
+			sats += vote.sats
+		}
+	}
+
+	parts := make([]string, 0, len(counts))
+	for _, category := range []string{voteReaction, voteEmoji, voteRepost, voteZap, voteHighlight, voteReply, voteNegative} {
+		count := counts[category]
+		if count == 0 {
+			continue
+		}
+		name := digestCountNames[category][1]
+		if count == 1 {
+			name = digestCountNames[category][0]
+		}
+		part := fmt.Sprintf("%d %s", count, name)
+		if category == voteZap && sats > 0 {
+			part += fmt.Sprintf(" (%d sats)", sats)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func buildDigest(kind nostr.Kind, metadata global.RelayMetadata, entries []digestEntry, now nostr.Timestamp) nostr.Event {
+	year, week := now.Time().ISOWeek()
+	title := fmt.Sprintf("%s digest, week %d of %d", metadata.GetName(), week, year)
+	summary := fmt.Sprintf("the %d events members appreciated the most on %s between %s and %s.",
+		len(entries), metadata.GetName(), (now - digestInterval).Time().Format("January 2"), now.Time().Format("January 2"))
+
+	digest := nostr.Event{
+		Kind:      kind,
+		CreatedAt: now,
+		Tags: nostr.Tags{
+			{"d", fmt.Sprintf("%s%d-W%02d", feed.DigestPrefix, year, week)},
+			{"title", title},
+			{"published_at", fmt.Sprint(now)},
+			{"alt", title},
+		},
+	}
+
+	if kind == 30004 {
+		// a NIP-51 curation set, ordered from best to worst
+		digest.Tags = append(digest.Tags, nostr.Tag{"description", summary})
+		for _, entry := range entries {
+			tagName := "e"
+			if entry.event.Kind.IsAddressable() {
+				tagName = "a"
+			}
+			digest.Tags = append(digest.Tags, nostr.Tag{tagName, pointerTagValue(entry.event), metadata.GetServiceURL()})
+		}
+		return digest
+	}
+
+	// a long-form article
+	digest.Tags = append(digest.Tags, nostr.Tag{"summary", summary})
+	var content strings.Builder
+	content.WriteString(summary)
+	content.WriteString("\n\n")
+	for i, entry := range entries {
+		pointer := nostr.Pointer(nostr.EventPointer{ID: entry.event.ID, Author: entry.event.PubKey, Kind: entry.event.Kind, Relays: []string{metadata.GetServiceURL()}})
+		if entry.event.Kind.IsAddressable() {
+			pointer = nostr.EntityPointer{PublicKey: entry.event.PubKey, Kind: entry.event.Kind, Identifier: entry.event.Tags.GetD(), Relays: []string{metadata.GetServiceURL()}}
+		}
+
+		headline := feed.Title(entry.event.Content, 100)
+		if t := entry.event.Tags.Find("title"); t != nil {
+			headline = t[1]
+		}
+		fmt.Fprintf(&content, "%d. **%s** by nostr:%s\n   %s\n", i+1, headline, nip19.EncodeNpub(entry.event.PubKey), global.Settings.GetExternalLink(pointer))
+		if entry.counts != "" {
+			fmt.Fprintf(&content, "   %s\n", entry.counts)
+		}
+		content.WriteString("\n")
+
+		digest.Tags = append(digest.Tags, nostr.Tag{"q", pointerTagValue(entry.event), metadata.GetServiceURL()})
+	}
+	digest.Content = strings.TrimSpace(content.String())
+	return digest
+}
+
+func pointerTagValue(evt nostr.Event) string {
+	if evt.Kind.IsAddressable() {
+		return nostr.EntityPointer{PublicKey: evt.PubKey, Kind: evt.Kind, Identifier: evt.Tags.GetD()}.AsTagReference()
+	}
+	return evt.ID.Hex()
+}
@@ -0,0 +1,281 @@
+package favorites
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/sdk"
+
+	"github.com/fiatjaf/pyramid/global"
+)
+
+// annotations are kind 1985 events signed by the relay in this namespace, one for each member that
+// favorited an event. they point to the event with an "e" tag and to the member with a "curator" tag,
+// label it with the collections the member filed it into and have the member's comment as content.
+const AnnotationNamespace = "pyramid/favorites"
+
+// the "d" tag of the relay-signed NIP-51 set of each collection starts with this
+const CollectionPrefix = "favorites-"
+
+type Annotation struct {
+	nostr.Event
+	Target      nostr.ID
+	Curator     nostr.PubKey
+	Collections []string
+}
+
+type Collection struct {
+	Name  string
+	Count int
+}
+
+func parseAnnotation(evt nostr.Event) (Annotation, bool) {
+	a := Annotation{Event: evt}
+
+	e := evt.Tags.Find("e")
+	if e == nil {
+		return a, false
+	}
+	id, err := nostr.IDFromHex(e[1])
+	if err != nil {
+		return a, false
+	}
+	a.Target = id
+
+	curator := evt.Tags.Find("curator")
+	if curator == nil {
+		return a, false
+	}
+	pk, err := nostr.PubKeyFromHex(curator[1])
+	if err != nil {
+		return a, false
+	}
+	a.Curator = pk
+
+	for _, tag := range evt.Tags {
+		if len(tag) >= 3 && tag[0] == "l" && tag[2] == AnnotationNamespace {
+			a.Collections = append(a.Collections, tag[1])
+		}
+	}
+	return a, true
+}
+
+// Annotations returns the latest annotations, optionally only those in a collection or by a curator.
+func Annotations(collection string, curator nostr.PubKey) []Annotation {
+	filter := nostr.Filter{
+		Kinds:   []nostr.Kind{1985},
+		Authors: []nostr.PubKey{global.Settings.RelayInternalSecretKey.Public()},
+		Tags:    nostr.TagMap{"L": []string{AnnotationNamespace}},
+	}
+	if collection != "" {
+		filter.Tags["l"] = []string{collection}
+	}
+
+	annotations := make([]Annotation, 0, 50)
+	for evt := range global.IL.Favorites.QueryEvents(filter, 10_000) {
+		a, ok := parseAnnotation(evt)
+		if !ok {
+			continue
+		}
+		// only single-letter tags are indexed
+		if curator != nostr.ZeroPK && a.Curator != curator {
+			continue
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations
+}
+
+// Collections lists the collection names members have used, the most used first.
+func Collections() []Collection {
+	counts := make(map[string]int)
+	for _, a := range Annotations("", nostr.ZeroPK) {
+		for _, name := range a.Collections {
+			counts[name]++
+		}
+	}
+
+	collections := make([]Collection, 0, len(counts))
+	for name, count := range counts {
+		collections = append(collections, Collection{name, count})
+	}
+	slices.SortFunc(collections, func(a, b Collection) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return collections
+}
+
+// Curators lists the members that favorited something.
+func Curators() []nostr.PubKey {
+	curators := make([]nostr.PubKey, 0, 20)
+	for _, a := range Annotations("", nostr.ZeroPK) {
+		if !slices.Contains(curators, a.Curator) {
+			curators = append(curators, a.Curator)
+		}
+	}
+	return curators
+}
+
+// CollectionName normalizes what members type as a collection name.
+func CollectionName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// annotate records that a member favorited an event, replacing what they said about it before.
+func annotate(curator nostr.PubKey, target nostr.Event, comment string, collections []string) error {
+	affected := make([]string, 0, len(collections))
+
+	previous := findAnnotation(curator, target.ID)
+	if previous != nil {
+		if err := global.IL.Favorites.DeleteEvent(previous.ID); err != nil {
+			return fmt.Errorf("failed to delete previous annotation: %w", err)
+		}
+		affected = append(affected, previous.Collections...)
+	}
+
+	annotation := nostr.Event{
+		Kind:      1985,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"L", AnnotationNamespace},
+			{"e", target.ID.Hex()},
+			{"p", target.PubKey.Hex()},
+			{"k", fmt.Sprint(target.Kind)},
+			{"curator", curator.Hex()},
+		},
+		Content: strings.TrimSpace(comment),
+	}
+	if target.Kind.IsAddressable() {
+		annotation.Tags = append(annotation.Tags, nostr.Tag{"a", pointerTagValue(target)})
+	}
+	added := make([]string, 0, len(collections))
+	for _, name := range collections {
+		if name = CollectionName(name); name == "" || slices.Contains(added, name) {
+			continue
+		}
+		annotation.Tags = append(annotation.Tags, nostr.Tag{"l", name, AnnotationNamespace})
+		added = append(added, name)
+	}
+	affected = append(affected, added...)
+
+	if err := annotation.Sign(global.Settings.RelayInternalSecretKey); err != nil {
+		return err
+	}
+	if err := global.IL.Favorites.SaveEvent(annotation); err != nil {
+		return fmt.Errorf("failed to save annotation: %w", err)
+	}
+	Relay.BroadcastEvent(annotation)
+
+	rebuildCollections(affected)
+	return nil
+}
+
+// forgetAnnotations deletes the annotations of an event that left favorites.
+func forgetAnnotations(target nostr.ID) {
+	affected := make([]string, 0, 4)
+	for evt := range global.IL.Favorites.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{1985},
+		Authors: []nostr.PubKey{global.Settings.RelayInternalSecretKey.Public()},
+		Tags:    nostr.TagMap{"L": []string{AnnotationNamespace}, "e": []string{target.Hex()}},
+	}, 1000) {
+		if a, ok := parseAnnotation(evt); ok {
+			affected = append(affected, a.Collections...)
+		}
+		global.IL.Favorites.DeleteEvent(evt.ID)
+	}
+	rebuildCollections(affected)
+}
+
+func findAnnotation(curator nostr.PubKey, target nostr.ID) *Annotation {
+	for evt := range global.IL.Favorites.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{1985},
+		Authors: []nostr.PubKey{global.Settings.RelayInternalSecretKey.Public()},
+		Tags:    nostr.TagMap{"L": []string{AnnotationNamespace}, "e": []string{target.Hex()}},
+	}, 1000) {
+		if a, ok := parseAnnotation(evt); ok && a.Curator == curator {
+			return &a
+		}
+	}
+	return nil
+}
+
+// rebuildCollections publishes the NIP-51 curation set (kind 30004) of each collection again,
+// with its events from the most recently favorited, or deletes it if it became empty.
+func rebuildCollections(names []string) {
+	slices.Sort(names)
+	for _, name := range slices.Compact(names) {
+		d := CollectionPrefix + name
+		annotations := Annotations(name, nostr.ZeroPK)
+
+		if len(annotations) == 0 {
+			for set := range global.IL.Favorites.QueryEvents(nostr.Filter{
+				Kinds:   []nostr.Kind{30004},
+				Authors: []nostr.PubKey{global.Settings.RelayInternalSecretKey.Public()},
+				Tags:    nostr.TagMap{"d": []string{d}},
+			}, 1) {
+				global.IL.Favorites.DeleteEvent(set.ID)
+			}
+			continue
+		}
+
+		set := nostr.Event{
+			Kind:      30004,
+			CreatedAt: nostr.Now(),
+			Tags: nostr.Tags{
+				{"d", d},
+				{"title", name},
+				{"description", fmt.Sprintf("%s, favorited by members of %s", name, global.Settings.RelayName)},
+				{"alt", "curation set: " + name},
+			},
+		}
+		for _, a := range annotations {
+			tag := nostr.Tag{"e", a.Target.Hex(), global.Settings.Favorites.GetServiceURL()}
+			if addr := a.Tags.Find("a"); addr != nil {
+				tag = nostr.Tag{"a", addr[1], global.Settings.Favorites.GetServiceURL()}
+			}
+			if !slices.ContainsFunc(set.Tags, func(t nostr.Tag) bool { return t[0] == tag[0] && t[1] == tag[1] }) {
+				set.Tags = append(set.Tags, tag)
+			}
+		}
+
+		if err := set.Sign(global.Settings.RelayInternalSecretKey); err != nil {
+			continue
+		}
+		if _, err := global.IL.Favorites.ReplaceEvent(set); err != nil {
+			log.Warn().Err(err).Str("collection", name).Msg("failed to save collection")
+			continue
+		}
+		Relay.BroadcastEvent(set)
+	}
+}
+
+// fetchFavorite gets an event from favorites or from elsewhere given a nevent, naddr, note or id.
+func fetchFavorite(ctx context.Context, input string) (*nostr.Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	input = strings.TrimPrefix(strings.TrimSpace(input), "nostr:")
+	evt, _, err := global.Nostr.FetchSpecificEventFromInput(ctx, input, sdk.FetchSpecificEventParameters{})
+	if err != nil {
+		return nil, err
+	}
+	if evt == nil {
+		return nil, fmt.Errorf("event not found")
+	}
+	return evt, nil
+}
+
+func pointerTagValue(evt nostr.Event) string {
+	if evt.Kind.IsAddressable() {
+		return nostr.EntityPointer{PublicKey: evt.PubKey, Kind: evt.Kind, Identifier: evt.Tags.GetD()}.AsTagReference()
+	}
+	return evt.ID.Hex()
+}
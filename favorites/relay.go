@@ -2,15 +2,19 @@ package favorites
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/eventstore"
 	"fiatjaf.com/nostr/khatru"
 	"fiatjaf.com/nostr/khatru/policies"
 	"fiatjaf.com/nostr/nip11"
 
+	"github.com/fiatjaf/pyramid/feed"
 	"github.com/fiatjaf/pyramid/global"
 	"github.com/fiatjaf/pyramid/pyramid"
 )
@@ -18,6 +22,9 @@ import (
 var (
 	log   = global.Log.With().Str("relay", "favorites").Logger()
 	Relay *khatru.Relay
+
+	// what every event stored here must pass, whether a member publishes it or it is fetched for them
+	storable func(context.Context, nostr.Event) (bool, string)
 )
 
 func Init() {
@@ -36,10 +43,7 @@ func setupDisabled() {
 	global.CleanupRelay(Relay)
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/"+global.Settings.Favorites.HTTPBasePath+"/", func(w http.ResponseWriter, r *http.Request) {
-		loggedUser, _ := global.GetLoggedUser(r)
-		favoritesPage(loggedUser).Render(r.Context(), w)
-	})
+	mux.HandleFunc("/"+global.Settings.Favorites.HTTPBasePath+"/", pageHandler)
 	mux.HandleFunc("POST /"+global.Settings.Favorites.HTTPBasePath+"/enable", enableHandler)
 	Relay.SetRouter(mux)
 }
@@ -82,7 +86,7 @@ func setupEnabled() {
 		global.RejectTooManyOpenSubscriptions,
 	)
 
-	Relay.OnEvent = policies.SeqEvent(
+	storable = policies.SeqEvent(
 		global.RejectInternalKinds,
 		policies.PreventLargeContent(global.Settings.Limits.MaxEventSize),
 		policies.PreventTooManyIndexableTags(global.Settings.Limits.MaxIndexableTags, []nostr.Kind{3}, nil),
@@ -91,7 +95,13 @@ func setupEnabled() {
 			if !global.KindIsAllowed(evt.Kind) {
 				return true, "blocked: kind unallowed"
 			}
+			return false, ""
+		},
+	)
 
+	Relay.OnEvent = policies.SeqEvent(
+		storable,
+		func(ctx context.Context, evt nostr.Event) (bool, string) {
 			authedPublicKeys := khatru.GetAllAuthed(ctx)
 			if len(authedPublicKeys) == 0 {
 				return true, "auth-required: must be a relay member"
@@ -112,12 +122,25 @@ func setupEnabled() {
 		},
 	)
 
+	Relay.OnEventSaved = func(ctx context.Context, event nostr.Event) {
+		// remember who favorited it, without a comment or collections
+		for _, authed := range khatru.GetAllAuthed(ctx) {
+			if pyramid.IsMember(authed) {
+				if findAnnotation(authed, event.ID) == nil {
+					if err := annotate(authed, event, "", nil); err != nil {
+						log.Warn().Err(err).Msg("failed to annotate favorite")
+					}
+				}
+				return
+			}
+		}
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/"+global.Settings.Favorites.HTTPBasePath+"/", func(w http.ResponseWriter, r *http.Request) {
-		loggedUser, _ := global.GetLoggedUser(r)
-		favoritesPage(loggedUser).Render(r.Context(), w)
-	})
+	mux.HandleFunc("/"+global.Settings.Favorites.HTTPBasePath+"/", pageHandler)
 	mux.HandleFunc("POST /"+global.Settings.Favorites.HTTPBasePath+"/disable", disableHandler)
+	mux.HandleFunc("POST /"+global.Settings.Favorites.HTTPBasePath+"/annotate", annotateHandler)
+	feed.Register(mux, func() global.RelayMetadata { return global.Settings.Favorites.RelayMetadata }, global.IL.Favorites)
 	Relay.SetRouter(mux)
 }
 
@@ -225,5 +248,67 @@ func banEventHandler(ctx context.Context, id nostr.ID, reason string) error {
 		log.Info().Str("caller", caller.Hex()).Str("id", id.Hex()).Str("reason", reason).Msg("favorites banevent called by author")
 	}
 
-	return global.IL.Favorites.DeleteEvent(id)
+	if err := global.IL.Favorites.DeleteEvent(id); err != nil {
+		return err
+	}
+	forgetAnnotations(id)
+	return nil
+}
+
+func pageHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+
+	collection := CollectionName(r.URL.Query().Get("collection"))
+	var curator nostr.PubKey
+	if c := r.URL.Query().Get("curator"); c != "" {
+		curator = global.PubKeyFromInput(c)
+	}
+
+	favoritesPage(loggedUser, collection, curator).Render(r.Context(), w)
+}
+
+func annotateHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsMember(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	target, err := fetchFavorite(r.Context(), r.PostFormValue("event"))
+	if err != nil {
+		http.Error(w, "failed to find event: "+err.Error(), 400)
+		return
+	}
+	if target.PubKey == loggedUser {
+		http.Error(w, "can't favorite your own event", 400)
+		return
+	}
+	if reject, msg := storable(r.Context(), *target); reject {
+		http.Error(w, msg, 400)
+		return
+	}
+	if !target.VerifySignature() {
+		http.Error(w, "invalid signature", 400)
+		return
+	}
+
+	if target.Kind.IsAddressable() || target.Kind.IsReplaceable() {
+		_, err = global.IL.Favorites.ReplaceEvent(*target)
+	} else {
+		err = global.IL.Favorites.SaveEvent(*target)
+	}
+	if err == nil {
+		Relay.BroadcastEvent(*target)
+	} else if !errors.Is(err, eventstore.ErrDupEvent) {
+		http.Error(w, "failed to save event: "+err.Error(), 500)
+		return
+	}
+
+	if err := annotate(loggedUser, *target, r.PostFormValue("comment"), strings.Split(r.PostFormValue("collections"), ",")); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	log.Info().Str("curator", loggedUser.Hex()).Str("event", target.ID.Hex()).Msg("favorite annotated")
+	http.Redirect(w, r, global.Settings.Favorites.GetPageURL(), 302)
 }
@@ -0,0 +1,102 @@
+package feed
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/eventstore/mmm"
+
+	"github.com/fiatjaf/pyramid/global"
+)
+
+// digests are kind 30023 or 30004 events signed by the relay with a "d" tag starting with this
+const DigestPrefix = "digest-"
+
+func queryDigests(layer *mmm.IndexingLayer, limit int) func(yield func(nostr.Event) bool) {
+	return func(yield func(nostr.Event) bool) {
+		for evt := range layer.QueryEvents(nostr.Filter{
+			Kinds:   []nostr.Kind{30023, 30004},
+			Authors: []nostr.PubKey{global.Settings.RelayInternalSecretKey.Public()},
+		}, limit) {
+			if strings.HasPrefix(evt.Tags.GetD(), DigestPrefix) {
+				if !yield(evt) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// LatestDigest returns the last digest published in a curated relay, if any.
+func LatestDigest(layer *mmm.IndexingLayer) *nostr.Event {
+	for evt := range queryDigests(layer, 10) {
+		return &evt
+	}
+	return nil
+}
+
+// Digests is the feed of the digests published in a curated relay.
+func Digests(metadata global.RelayMetadata, layer *mmm.IndexingLayer) Feed {
+	f := Feed{
+		Title:       metadata.GetName() + " digest",
+		Link:        metadata.GetPageURL(),
+		SelfURL:     metadata.GetPageURL() + "digest.xml",
+		Description: metadata.GetDescription(),
+		Icon:        metadata.GetIcon(),
+	}
+
+	for evt := range queryDigests(layer, 20) {
+		item := Item{
+			ID:        "nostr:" + nostr.EntityPointer{PublicKey: evt.PubKey, Kind: evt.Kind, Identifier: evt.Tags.GetD()}.AsTagReference(),
+			Link:      global.Settings.GetExternalLink(nostr.EntityPointer{PublicKey: evt.PubKey, Kind: evt.Kind, Identifier: evt.Tags.GetD(), Relays: []string{metadata.GetServiceURL()}}),
+			Author:    metadata.GetName(),
+			Published: evt.CreatedAt.Time(),
+		}
+		if title := evt.Tags.Find("title"); title != nil {
+			item.Title = title[1]
+		}
+
+		if evt.Kind == 30023 {
+			item.Content = TextToHTML(evt.Content)
+		} else {
+			// curation sets only have references, so we list links to them
+			var b strings.Builder
+			if description := evt.Tags.Find("description"); description != nil {
+				b.WriteString(TextToHTML(description[1]))
+			}
+			b.WriteString("<ol>")
+			for _, tag := range evt.Tags {
+				if len(tag) < 2 {
+					continue
+				}
+				var pointer nostr.Pointer
+				switch tag[0] {
+				case "e":
+					if id, err := nostr.IDFromHex(tag[1]); err == nil {
+						pointer = nostr.EventPointer{ID: id, Relays: []string{metadata.GetServiceURL()}}
+					}
+				case "a":
+					if ptr, err := nostr.ParseAddrString(tag[1]); err == nil {
+						ptr.Relays = []string{metadata.GetServiceURL()}
+						pointer = ptr
+					}
+				}
+				if pointer != nil {
+					link := html.EscapeString(global.Settings.GetExternalLink(pointer))
+					fmt.Fprintf(&b, `<li><a href="%s">%s</a></li>`, link, link)
+				}
+			}
+			b.WriteString("</ol>")
+			item.Content = b.String()
+		}
+
+		if item.Published.After(f.Updated) {
+			f.Updated = item.Published
+		}
+		f.Items = append(f.Items, item)
+	}
+
+	return f
+}
@@ -0,0 +1,220 @@
+package feed
+
+import (
+	"encoding/xml"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Feed is rendered as RSS 2.0 by default or as Atom when requested with ?format=atom.
+type Feed struct {
+	Title       string
+	Link        string // the page of the relay
+	SelfURL     string // where the feed itself is served
+	Description string
+	Icon        string
+	Updated     time.Time
+	Items       []Item
+}
+
+type Item struct {
+	ID        string // a stable identifier, like a nostr: URI
+	Title     string
+	Link      string
+	Author    string
+	Published time.Time
+	Updated   time.Time
+	Content   string // HTML
+}
+
+// Serve writes the feed in the format asked for in the query string.
+func Serve(w http.ResponseWriter, r *http.Request, feed Feed) {
+	if r.URL.Query().Get("format") == "atom" {
+		WriteAtom(w, feed)
+	} else {
+		WriteRSS(w, feed)
+	}
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	AtomLink      atomLink  `xml:"atom:link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Image         *rssImage `xml:"image,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssImage struct {
+	URL   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link,omitempty"`
+	GUID        rssGUID `xml:"guid"`
+	Author      string  `xml:"author,omitempty"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func WriteRSS(w http.ResponseWriter, feed Feed) {
+	doc := rssDocument{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       feed.Title,
+			Link:        feed.Link,
+			AtomLink:    atomLink{Href: feed.SelfURL, Rel: "self", Type: "application/rss+xml"},
+			Description: feed.Description,
+			Items:       make([]rssItem, 0, len(feed.Items)),
+		},
+	}
+	if !feed.Updated.IsZero() {
+		doc.Channel.LastBuildDate = feed.Updated.Format(time.RFC1123Z)
+	}
+	if feed.Icon != "" {
+		doc.Channel.Image = &rssImage{URL: feed.Icon, Title: feed.Title, Link: feed.Link}
+	}
+	for _, item := range feed.Items {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			GUID:        rssGUID{Value: item.ID},
+			Author:      item.Author,
+			PubDate:     item.Published.Format(time.RFC1123Z),
+			Description: item.Content,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	writeXML(w, doc)
+}
+
+type atomDocument struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	ID       string      `xml:"id"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Icon     string      `xml:"icon,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Link      *atomLink   `xml:"link,omitempty"`
+	Author    *atomAuthor `xml:"author,omitempty"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+func WriteAtom(w http.ResponseWriter, feed Feed) {
+	updated := feed.Updated
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+
+	doc := atomDocument{
+		Title:    feed.Title,
+		ID:       feed.SelfURL,
+		Subtitle: feed.Description,
+		Icon:     feed.Icon,
+		Updated:  updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: feed.Link, Rel: "alternate", Type: "text/html"},
+			{Href: feed.SelfURL, Rel: "self", Type: "application/atom+xml"},
+		},
+		Entries: make([]atomEntry, 0, len(feed.Items)),
+	}
+	for _, item := range feed.Items {
+		entry := atomEntry{
+			Title:     item.Title,
+			ID:        item.ID,
+			Published: item.Published.Format(time.RFC3339),
+			Updated:   item.Published.Format(time.RFC3339),
+			Content:   atomContent{Type: "html", Value: item.Content},
+		}
+		if !item.Updated.IsZero() {
+			entry.Updated = item.Updated.Format(time.RFC3339)
+		}
+		if item.Link != "" {
+			entry.Link = &atomLink{Href: item.Link, Rel: "alternate", Type: "text/html"}
+		}
+		if item.Author != "" {
+			entry.Author = &atomAuthor{Name: item.Author}
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	writeXML(w, doc)
+}
+
+func writeXML(w http.ResponseWriter, doc any) {
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(doc)
+}
+
+// TextToHTML turns plain text (or markdown, which is read as text) into escaped HTML paragraphs.
+func TextToHTML(text string) string {
+	var b strings.Builder
+	for _, paragraph := range strings.Split(strings.TrimSpace(text), "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		b.WriteString(strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>"))
+		b.WriteString("</p>")
+	}
+	return b.String()
+}
+
+// Title makes an item title out of the beginning of a text.
+func Title(text string, length int) string {
+	text = strings.TrimSpace(text)
+	if line, _, found := strings.Cut(text, "\n"); found {
+		text = line
+	}
+	if runes := []rune(text); len(runes) > length {
+		return string(runes[0:length]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,174 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/eventstore/mmm"
+	"fiatjaf.com/nostr/nip70"
+
+	"github.com/fiatjaf/pyramid/global"
+)
+
+// RecentFilter is what goes into the feed of a relay: the latest notes and articles.
+var RecentFilter = nostr.Filter{Kinds: []nostr.Kind{1, 30023}}
+
+const recentLimit = 50
+
+// Header is the feed of a sub-relay without items, to be passed to Recent.
+func Header(metadata global.RelayMetadata, self string) Feed {
+	return Feed{
+		Title:       metadata.GetName(),
+		Link:        metadata.GetPageURL(),
+		SelfURL:     metadata.GetPageURL() + self,
+		Description: metadata.GetDescription(),
+		Icon:        metadata.GetIcon(),
+	}
+}
+
+// Recent fills a feed with the events from a query, leaving paywalled and protected events out
+// and naming their authors after their profile metadata.
+func Recent(ctx context.Context, f Feed, events iter.Seq[nostr.Event]) Feed {
+	selected := make([]nostr.Event, 0, recentLimit)
+	authors := make(map[nostr.PubKey]string)
+	for evt := range events {
+		if evt.Kind != 1 && evt.Kind != 30023 {
+			continue
+		}
+		if nip70.IsProtected(evt) || nip70.HasEmbeddedProtected(evt) || evt.Tags.Has("nip63") {
+			continue
+		}
+		selected = append(selected, evt)
+		authors[evt.PubKey] = ""
+		if len(selected) == recentLimit {
+			break
+		}
+	}
+
+	// resolve author names, giving up on the ones that take too long
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, pubkey := range slices.Collect(maps.Keys(authors)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := global.Nostr.FetchProfileMetadata(ctx, pubkey).ShortName()
+			mu.Lock()
+			authors[pubkey] = name
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	f.Items = make([]Item, 0, len(selected))
+	for _, evt := range selected {
+		var pointer nostr.Pointer = nostr.EventPointer{ID: evt.ID, Author: evt.PubKey, Kind: evt.Kind}
+		if evt.Kind.IsAddressable() {
+			pointer = nostr.EntityPointer{PublicKey: evt.PubKey, Kind: evt.Kind, Identifier: evt.Tags.GetD()}
+		}
+
+		item := Item{
+			ID:        "nostr:" + pointer.AsTagReference(),
+			Link:      global.Settings.GetExternalLink(pointer),
+			Author:    authors[evt.PubKey],
+			Title:     Title(evt.Content, 80),
+			Published: evt.CreatedAt.Time(),
+			Content:   TextToHTML(evt.Content),
+		}
+		if evt.Kind == 30023 {
+			if title := evt.Tags.Find("title"); title != nil {
+				item.Title = title[1]
+			}
+			if published := evt.Tags.Find("published_at"); published != nil {
+				if ts, err := strconv.ParseInt(published[1], 10, 64); err == nil {
+					item.Published = time.Unix(ts, 0)
+					item.Updated = evt.CreatedAt.Time()
+				}
+			}
+		}
+
+		if evt.CreatedAt.Time().After(f.Updated) {
+			f.Updated = evt.CreatedAt.Time()
+		}
+		f.Items = append(f.Items, item)
+	}
+
+	return f
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Icon        string         `json:"icon,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	Title         string           `json:"title,omitempty"`
+	ContentHTML   string           `json:"content_html"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// WriteJSON writes the feed as a JSON Feed 1.1.
+func WriteJSON(w http.ResponseWriter, f Feed) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.Link,
+		FeedURL:     f.SelfURL,
+		Description: f.Description,
+		Icon:        f.Icon,
+		Items:       make([]jsonFeedItem, 0, len(f.Items)),
+	}
+	for _, item := range f.Items {
+		ji := jsonFeedItem{
+			ID:            item.ID,
+			URL:           item.Link,
+			Title:         item.Title,
+			ContentHTML:   item.Content,
+			DatePublished: item.Published.Format(time.RFC3339),
+		}
+		if !item.Updated.IsZero() {
+			ji.DateModified = item.Updated.Format(time.RFC3339)
+		}
+		if item.Author != "" {
+			ji.Authors = []jsonFeedAuthor{{Name: item.Author}}
+		}
+		doc.Items = append(doc.Items, ji)
+	}
+
+	w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// Register adds the /feed.xml and /feed.json routes of a sub-relay that serves the events in its layer.
+func Register(mux *http.ServeMux, metadata func() global.RelayMetadata, layer *mmm.IndexingLayer) {
+	base := "/" + metadata().HTTPBasePath + "/"
+	mux.HandleFunc("GET "+base+"feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		Serve(w, r, Recent(r.Context(), Header(metadata(), "feed.xml"), layer.QueryEvents(RecentFilter, recentLimit*4)))
+	})
+	mux.HandleFunc("GET "+base+"feed.json", func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, Recent(r.Context(), Header(metadata(), "feed.json"), layer.QueryEvents(RecentFilter, recentLimit*4)))
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/fiatjaf/pyramid/feed"
+	"github.com/fiatjaf/pyramid/global"
+)
+
+// mainFeed has the latest notes and articles from members, as anyone without authentication sees them.
+func mainFeed(r *http.Request, self string) feed.Feed {
+	home := global.Settings.HTTPScheme() + global.Settings.Domain + "/"
+	header := feed.Feed{
+		Title:       global.Settings.RelayName,
+		Link:        home,
+		SelfURL:     home + self,
+		Description: global.Settings.RelayDescription,
+		Icon:        global.Settings.RelayIcon,
+	}
+	return feed.Recent(r.Context(), header, queryStored(r.Context(), feed.RecentFilter))
+}
+
+func mainFeedXMLHandler(w http.ResponseWriter, r *http.Request) {
+	feed.Serve(w, r, mainFeed(r, "feed.xml"))
+}
+
+func mainFeedJSONHandler(w http.ResponseWriter, r *http.Request) {
+	feed.WriteJSON(w, mainFeed(r, "feed.json"))
+}
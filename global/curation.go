@@ -0,0 +1,27 @@
+package global
+
+import (
+	"fiatjaf.com/nostr"
+)
+
+// curation records are kind 1985 events signed by the relay in this namespace, labelled with the
+// relay the event was curated into and pointing to it with an "e" tag. their content says why.
+const CurationNamespace = "pyramid/curation"
+
+// what happened to the event in a curation record, in its "action" tag
+const (
+	CurationPromoted = "promoted" // it entered the relay
+	CurationDemoted  = "demoted"  // it left the relay because its score fell below the threshold
+	CurationExpired  = "expired"  // it left the relay to keep it within its size limit
+)
+
+// LatestCurationRecord returns the latest record of an event entering or leaving the given relay, if any.
+func LatestCurationRecord(relayId RelayID, id nostr.ID) *nostr.Event {
+	for record := range IL.Curation.QueryEvents(nostr.Filter{
+		Kinds: []nostr.Kind{1985},
+		Tags:  nostr.TagMap{"e": []string{id.Hex()}, "l": []string{relayId.String()}},
+	}, 1) {
+		return &record
+	}
+	return nil
+}
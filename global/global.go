@@ -151,6 +151,16 @@ func Init() error {
 		return fmt.Errorf("failed to ensure 'deleted-groups': %w", err)
 	}
 
+	IL.ReportDecisions, err = MMMM.EnsureLayer("report-decisions")
+	if err != nil {
+		return fmt.Errorf("failed to ensure 'report-decisions': %w", err)
+	}
+
+	IL.Curation, err = MMMM.EnsureLayer("curation")
+	if err != nil {
+		return fmt.Errorf("failed to ensure 'curation': %w", err)
+	}
+
 	for _, url := range []string{"https://api.ipify.org", "https://httpbin.org/ip"} {
 		resp, err := (&http.Client{Timeout: 10 * time.Second}).Get(url)
 		if err != nil {
@@ -178,6 +188,18 @@ func Init() error {
 	return nil
 }
 
+// InitConfig only reads the environment and the user settings,
+// for commands that need to know about the data directory but won't run the relay.
+func InitConfig() error {
+	if err := envconfig.Process("", &S); err != nil {
+		return fmt.Errorf("envconfig: %w", err)
+	}
+	if err := loadUserSettings(); err != nil {
+		return fmt.Errorf("user settings: %w", err)
+	}
+	return nil
+}
+
 func migrateGroupsLayer() error {
 	groupsDir := filepath.Join(S.DataPath, "groups")
 	_, err := os.Stat(groupsDir)
@@ -262,4 +284,10 @@ var IL struct {
 	// events from soft-deleted groups, including the kind-9008 delete-group events.
 	// only used internally and via the root-only /database inspector; not exposed by any relay.
 	DeletedGroups *mmm.IndexingLayer
+
+	// decisions taken by moderators on reports against the main relay, signed by the relay. not exposed by any relay.
+	ReportDecisions *mmm.IndexingLayer
+
+	// records of why events were curated into popular and uppermost, signed by the relay. not exposed by any relay.
+	Curation *mmm.IndexingLayer
 }
@@ -36,22 +36,42 @@ type UserSettings struct {
 	} `json:"theme"`
 
 	// general
-	BrowseURI                string `json:"browse_uri"`
-	LinkURL                  string `json:"link_url"`
-	MaxInvitesPerPerson      int    `json:"max_invites_per_person,omitempty"`
-	MaxInvitesAtEachLevel    []int  `json:"max_invites_at_each_level,omitempty"`
-	RequireCurrentTimestamp  bool   `json:"require_current_timestamp"`
-	AcceptScheduledEvents    bool   `json:"accept_scheduled_events"`
-	AllowAccessRequest       bool   `json:"allow_access_request"`
-	AllowEphemeralFromAnyone bool   `json:"allow_ephemeral_from_anyone"`
-	ValidateSchema           bool   `json:"validate_schema"`
+	BrowseURI             string `json:"browse_uri"`
+	LinkURL               string `json:"link_url"`
+	MaxInvitesPerPerson   int    `json:"max_invites_per_person,omitempty"`
+	MaxInvitesAtEachLevel []int  `json:"max_invites_at_each_level,omitempty"`
+	InviteQuota           struct {
+		ExtraInviteEveryDays   int `json:"extra_invite_every_days,omitempty"`   // 0 means membership age doesn't count
+		ExtraInviteEveryEvents int `json:"extra_invite_every_events,omitempty"` // 0 means activity doesn't count
+		MaxExtraInvites        int `json:"max_extra_invites,omitempty"`         // 0 means no cap
+		DroppedInviteePenalty  int `json:"dropped_invitee_penalty,omitempty"`   // invites lost for each dropped invitee
+	} `json:"invite_quota"`
+	DropQuorum struct {
+		DescendantsThreshold int `json:"descendants_threshold,omitempty"` // 0 means drops never need approval
+		CoSigners            int `json:"co_signers,omitempty"`
+	} `json:"drop_quorum"`
+	RequireCurrentTimestamp  bool `json:"require_current_timestamp"`
+	AcceptScheduledEvents    bool `json:"accept_scheduled_events"`
+	AllowAccessRequest       bool `json:"allow_access_request"`
+	AllowEphemeralFromAnyone bool `json:"allow_ephemeral_from_anyone"`
+	ValidateSchema           bool `json:"validate_schema"`
 	Search                   struct {
 		Enable    bool     `json:"enable"`
 		Languages []string `json:"languages"`
 	} `json:"search"`
+	ContentWarnings struct {
+		RequiredOnKinds         []nostr.Kind `json:"required_on_kinds,omitempty"`
+		RequiredOnHashtags      []string     `json:"required_on_hashtags,omitempty"` // lowercase, without the #
+		ReportThreshold         int          `json:"report_threshold,omitempty"`     // 0 means reports never add a label
+		HideFromUnauthenticated bool         `json:"hide_from_unauthenticated"`
+	} `json:"content_warnings"`
+	Reports struct {
+		AutoHideThreshold int `json:"auto_hide_threshold,omitempty"` // 0 means reported events are never hidden automatically
+	} `json:"reports"`
 
 	Paywall struct {
-		Enabled bool `json:"enable"`
+		Enabled      bool `json:"enable"`
+		TeaserLength int  `json:"teaser_length"` // characters shown to readers without access, 0 means no teasers
 	} `json:"paywall"`
 
 	NIP05 struct {
@@ -74,10 +94,16 @@ type UserSettings struct {
 	// per-relay
 	Internal struct {
 		RelayMetadata
+		Channels []InternalChannel `json:"channels,omitempty"`
 	} `json:"internal"`
 
 	Personal struct {
 		RelayMetadata
+		Encrypted            bool  `json:"encrypted,omitempty"`  // require members to store their events encrypted
+		MaxEvents            int   `json:"max_events,omitempty"` // per member, 0 means unlimited
+		MaxEventsAtEachLevel []int `json:"max_events_at_each_level,omitempty"`
+		MaxSize              int   `json:"max_size,omitempty"` // per member in kilobytes, 0 means unlimited
+		MaxSizeAtEachLevel   []int `json:"max_size_at_each_level,omitempty"`
 	} `json:"personal"`
 
 	Favorites struct {
@@ -87,6 +113,9 @@ type UserSettings struct {
 	Bookmarks struct {
 		RelayMetadata
 		AllAccess string `json:"all_access,omitempty"` // "public", "members", or "disabled"
+		Archive   bool   `json:"archive,omitempty"`    // keep readable snapshots of bookmarked web pages
+
+		Teams []TeamCollection `json:"teams,omitempty"`
 	} `json:"bookmarks"`
 
 	Inbox struct {
@@ -151,13 +180,41 @@ type UserSettings struct {
 	Popular struct {
 		RelayMetadata
 		PercentThreshold int `json:"percent_threshold"`
+		MaxEvents        int `json:"max_events"` // the oldest events are dropped beyond this, 0 (the default) means no limit
 	} `json:"popular"`
 
 	Uppermost struct {
 		RelayMetadata
 		PercentThreshold int `json:"percent_threshold"`
+		MaxEvents        int `json:"max_events"` // the oldest events are dropped beyond this, 0 (the default) means no limit
 	} `json:"uppermost"`
 
+	// how reactions in main are scored to qualify events for popular and uppermost.
+	// a member's vote counts once per event and the thresholds above are in the same points.
+	Curation struct {
+		WindowDays      int     `json:"window_days"`
+		HalfLifeHours   float64 `json:"half_life_hours"` // 0 means votes don't lose weight over time
+		ReactionWeight  float64 `json:"reaction_weight"` // kind 7 "+" or empty
+		EmojiWeight     float64 `json:"emoji_weight"`    // kind 7 with anything else
+		RepostWeight    float64 `json:"repost_weight"`
+		ReplyWeight     float64 `json:"reply_weight"` // don't count towards uppermost
+		HighlightWeight float64 `json:"highlight_weight"`
+		ZapWeight       float64 `json:"zap_weight"`
+		ZapSatsPerPoint int     `json:"zap_sats_per_point"` // zaps weigh one more point for each of these, 0 ignores amounts
+		NegativeWeight  float64 `json:"negative_weight"`    // subtracted for "-" and "⚠️" reactions
+		LevelWeight     float64 `json:"level_weight"`       // added to the multiplier of votes for each level down the tree, negative favors the top
+		MaxPerMember    float64 `json:"max_per_member"`
+
+		// curated events are scored again after this many days, counting all their votes, and dropped if they no longer qualify.
+		// 0, the default, keeps them forever
+		RetentionDays int `json:"retention_days"`
+
+		// a weekly digest of the best curated events is published in popular and uppermost as a kind 30023 article
+		// or a kind 30004 curation set, 0 means no digests
+		DigestKind nostr.Kind `json:"digest_kind"`
+		DigestSize int        `json:"digest_size"`
+	} `json:"curation"`
+
 	Moderated struct {
 		RelayMetadata
 		MinPoW uint `json:"min_pow"`
@@ -186,6 +243,25 @@ type Limits struct {
 	MaxQueryLimit          int `json:"max_query_limit"`
 }
 
+// TeamCollection is a bookmark collection shared by the members in its access list or with its role.
+type TeamCollection struct {
+	ID      string         `json:"id"` // also its sub-path under the bookmarks relay
+	Name    string         `json:"name"`
+	Owner   nostr.PubKey   `json:"owner"`
+	Members []nostr.PubKey `json:"members,omitempty"`
+	Role    string         `json:"role,omitempty"`
+}
+
+// InternalChannel is a part of the internal relay only visible to the members with its role
+// and in its subtree of the invite tree, or to all members if it has neither.
+type InternalChannel struct {
+	ID          string       `json:"id"` // the value of the "h" tag of its events
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Role        string       `json:"role,omitempty"`
+	Subtree     nostr.PubKey `json:"subtree,omitempty"` // this member and everybody they invited, directly or not
+}
+
 type RelayMetadata struct {
 	base string // identifies where this is
 
@@ -315,6 +391,25 @@ func (us UserSettings) GetMaxUserUploadSizeDisplay() string {
 	return strconv.Itoa(us.Blossom.MaxUserUploadSize)
 }
 
+func (us UserSettings) GetPersonalMaxEventsDisplay() string {
+	return perLevelDisplay(us.Personal.MaxEvents, us.Personal.MaxEventsAtEachLevel)
+}
+
+func (us UserSettings) GetPersonalMaxSizeDisplay() string {
+	return perLevelDisplay(us.Personal.MaxSize, us.Personal.MaxSizeAtEachLevel)
+}
+
+func perLevelDisplay(single int, levels []int) string {
+	if len(levels) > 0 {
+		parts := make([]string, len(levels))
+		for i, v := range levels {
+			parts[i] = strconv.Itoa(v)
+		}
+		return strings.Join(parts, "/")
+	}
+	return strconv.Itoa(single)
+}
+
 func getUserSettingsPath() string {
 	return filepath.Join(S.DataPath, "settings.json")
 }
@@ -349,6 +444,17 @@ func loadUserSettings() error {
 	Settings.Inbox.HellthreadLimit = 10
 	Settings.Popular.PercentThreshold = 20
 	Settings.Uppermost.PercentThreshold = 33
+	Settings.Curation.WindowDays = 7
+	Settings.Curation.ReactionWeight = 1
+	Settings.Curation.EmojiWeight = 1
+	Settings.Curation.RepostWeight = 1
+	Settings.Curation.ReplyWeight = 1
+	Settings.Curation.HighlightWeight = 1
+	Settings.Curation.ZapWeight = 1
+	Settings.Curation.NegativeWeight = 1
+	Settings.Curation.MaxPerMember = 3
+	Settings.Curation.DigestKind = 30023
+	Settings.Curation.DigestSize = 10
 	Settings.Internal.HTTPBasePath = "internal"
 	Settings.Personal.HTTPBasePath = "personal"
 	Settings.Favorites.HTTPBasePath = "favorites"
@@ -359,6 +465,8 @@ func loadUserSettings() error {
 	Settings.Uppermost.HTTPBasePath = "uppermost"
 	Settings.Moderated.HTTPBasePath = "moderated"
 
+	Settings.DropQuorum.CoSigners = 1
+
 	// Blossom settings
 	Settings.Blossom.MaxGroupMemberUploadSize = 1
 
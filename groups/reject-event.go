@@ -68,6 +68,9 @@ func RejectEvent(ctx context.Context, event nostr.Event) (reject bool, msg strin
 				// fine, we'll create the group
 				return true, "restricted: only members of this relay can create a group"
 			}
+			if pyramid.IsSuspended(event.PubKey, pyramid.ScopeGroups) {
+				return true, "restricted: you are suspended from creating groups"
+			}
 
 			// here we will just create the group
 			return false, ""
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -51,6 +52,14 @@ func actionHandler(w http.ResponseWriter, r *http.Request) {
 		type_ = pyramid.ActionDisable
 	case pyramid.ActionEnable:
 		type_ = pyramid.ActionEnable
+	case pyramid.ActionApproveDrop:
+		type_ = pyramid.ActionApproveDrop
+	case pyramid.ActionCancelDrop:
+		type_ = pyramid.ActionCancelDrop
+	case pyramid.ActionSuspend:
+		type_ = pyramid.ActionSuspend
+	case pyramid.ActionUnsuspend:
+		type_ = pyramid.ActionUnsuspend
 	default:
 		http.Error(w, "unknown action", 400)
 		return
@@ -58,7 +67,49 @@ func actionHandler(w http.ResponseWriter, r *http.Request) {
 	author, _ := global.GetLoggedUser(r)
 	target := global.PubKeyFromInput(r.PostFormValue("target"))
 
-	if err := pyramid.AddAction(type_, author, target); err != nil {
+	var err error
+	if type_ == pyramid.ActionInvite {
+		// optional membership terms, both given in days from now
+		var expires, probation nostr.Timestamp
+		if days, _ := strconv.Atoi(r.PostFormValue("expires_days")); days > 0 {
+			expires = nostr.Now() + nostr.Timestamp(days*24*60*60)
+		}
+		if days, _ := strconv.Atoi(r.PostFormValue("probation_days")); days > 0 {
+			probation = nostr.Now() + nostr.Timestamp(days*24*60*60)
+		}
+		err = pyramid.AddInviteAction(author, target, expires, probation)
+	} else if type_ == pyramid.ActionApproveDrop {
+		err = pyramid.ApproveDrop(author, target)
+	} else if type_ == pyramid.ActionCancelDrop || type_ == pyramid.ActionSuspend || type_ == pyramid.ActionUnsuspend {
+		switch type_ {
+		case pyramid.ActionCancelDrop:
+			err = pyramid.CancelDrop(author, target)
+		case pyramid.ActionSuspend:
+			// duration in days from now, no duration means until lifted manually
+			var until nostr.Timestamp
+			if days, _ := strconv.Atoi(r.PostFormValue("suspend_days")); days > 0 {
+				until = nostr.Now() + nostr.Timestamp(days*24*60*60)
+			}
+			err = pyramid.AddSuspendAction(author, target, r.PostForm["scope"], until)
+		case pyramid.ActionUnsuspend:
+			err = pyramid.AddAction(type_, author, target)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 403)
+			return
+		}
+		// these don't change membership, so there is nothing to publish
+		http.Redirect(w, r, "/u/"+target.Hex(), 302)
+		return
+	} else {
+		err = pyramid.AddAction(type_, author, target)
+	}
+	if errors.Is(err, pyramid.ErrDropPending) {
+		// the drop became (or still is) a proposal, show it on the member page
+		http.Redirect(w, r, "/u/"+target.Hex(), 302)
+		return
+	}
+	if err != nil {
 		http.Error(w, err.Error(), 403)
 		return
 	}
@@ -77,8 +128,11 @@ var relayMetadataUpdate = debounce.New(time.Minute)
 func settingsHandler(w http.ResponseWriter, r *http.Request) {
 	loggedUser, _ := global.GetLoggedUser(r)
 	if !pyramid.IsRoot(loggedUser) {
-		http.Error(w, "unauthorized", 403)
-		return
+		// members with the pin capability can change the pinned notes and nothing else
+		if r.Method != http.MethodPost || !pyramid.HasCapability(loggedUser, pyramid.CapPin) || !onlyPinnedFields(r) {
+			http.Error(w, "unauthorized", 403)
+			return
+		}
 	}
 
 	if r.Method == http.MethodPost {
@@ -134,6 +188,38 @@ func settingsHandler(w http.ResponseWriter, r *http.Request) {
 					global.Settings.MaxInvitesPerPerson, _ = strconv.Atoi(v[0])
 					global.Settings.MaxInvitesAtEachLevel = nil
 				}
+			case "extra_invite_every_days":
+				global.Settings.InviteQuota.ExtraInviteEveryDays, _ = strconv.Atoi(v[0])
+			case "extra_invite_every_events":
+				global.Settings.InviteQuota.ExtraInviteEveryEvents, _ = strconv.Atoi(v[0])
+			case "max_extra_invites":
+				global.Settings.InviteQuota.MaxExtraInvites, _ = strconv.Atoi(v[0])
+			case "dropped_invitee_penalty":
+				global.Settings.InviteQuota.DroppedInviteePenalty, _ = strconv.Atoi(v[0])
+			case "drop_descendants_threshold":
+				global.Settings.DropQuorum.DescendantsThreshold, _ = strconv.Atoi(v[0])
+			case "drop_co_signers":
+				global.Settings.DropQuorum.CoSigners, _ = strconv.Atoi(v[0])
+			case "content_warning_kinds":
+				global.Settings.ContentWarnings.RequiredOnKinds = nil
+				for _, s := range strings.Split(v[0], ",") {
+					if kind, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16); err == nil {
+						global.Settings.ContentWarnings.RequiredOnKinds = append(global.Settings.ContentWarnings.RequiredOnKinds, nostr.Kind(kind))
+					}
+				}
+			case "content_warning_hashtags":
+				global.Settings.ContentWarnings.RequiredOnHashtags = nil
+				for _, s := range strings.FieldsFunc(v[0], func(r rune) bool { return r == ',' || r == ' ' }) {
+					if tag := strings.ToLower(strings.TrimPrefix(s, "#")); tag != "" {
+						global.Settings.ContentWarnings.RequiredOnHashtags = append(global.Settings.ContentWarnings.RequiredOnHashtags, tag)
+					}
+				}
+			case "content_warning_report_threshold":
+				global.Settings.ContentWarnings.ReportThreshold, _ = strconv.Atoi(v[0])
+			case "reports_auto_hide_threshold":
+				global.Settings.Reports.AutoHideThreshold, _ = strconv.Atoi(v[0])
+			case "content_warning_hide":
+				global.Settings.ContentWarnings.HideFromUnauthenticated = v[0] == "on"
 			case "max_event_size":
 				global.Settings.Limits.MaxEventSize, _ = strconv.Atoi(v[0])
 			case "max_subscriptions_open":
@@ -322,6 +408,11 @@ func settingsHandler(w http.ResponseWriter, r *http.Request) {
 				bookmarks.Relay.ServiceURL = global.Settings.Bookmarks.GetServiceURL()
 				bookmarks.Init()
 				go restartSoon()
+			case "bookmarks_archive":
+				global.Settings.Bookmarks.Archive = v[0] == "on"
+				if global.Settings.Bookmarks.Archive {
+					go bookmarks.ArchiveAll()
+				}
 			case "bookmarks_all_access":
 				switch v[0] {
 				case "public", "members", "disabled":
@@ -422,6 +513,12 @@ func settingsHandler(w http.ResponseWriter, r *http.Request) {
 				global.Settings.Personal.Description = v[0]
 			case "personal_icon":
 				global.Settings.Personal.Icon = v[0]
+			case "personal_encrypted":
+				global.Settings.Personal.Encrypted = v[0] == "on"
+			case "personal_max_events":
+				global.Settings.Personal.MaxEvents, global.Settings.Personal.MaxEventsAtEachLevel = parsePerLevel(v[0])
+			case "personal_max_size":
+				global.Settings.Personal.MaxSize, global.Settings.Personal.MaxSizeAtEachLevel = parsePerLevel(v[0])
 			case "personal_httpBasePath":
 				if len(v[0]) == 0 || !justLetters.MatchString(v[0]) {
 					http.Error(w, "invalid path must contain only ascii letters and numbers", 400)
@@ -548,6 +645,66 @@ func settingsHandler(w http.ResponseWriter, r *http.Request) {
 					global.Settings.Uppermost.PercentThreshold = val
 				}
 				//
+				// curation scoring, shared by popular and uppermost
+			case "curation_window_days":
+				if val, err := strconv.Atoi(v[0]); err == nil && val > 0 {
+					global.Settings.Curation.WindowDays = val
+				}
+			case "curation_retention_days":
+				if val, err := strconv.Atoi(v[0]); err == nil && val >= 0 {
+					global.Settings.Curation.RetentionDays = val
+				}
+			case "popular_max_events":
+				if val, err := strconv.Atoi(v[0]); err == nil && val >= 0 {
+					global.Settings.Popular.MaxEvents = val
+				}
+			case "uppermost_max_events":
+				if val, err := strconv.Atoi(v[0]); err == nil && val >= 0 {
+					global.Settings.Uppermost.MaxEvents = val
+				}
+			case "curation_digest_kind":
+				if val, err := strconv.Atoi(v[0]); err == nil && (val == 0 || val == 30023 || val == 30004) {
+					global.Settings.Curation.DigestKind = nostr.Kind(val)
+				}
+			case "curation_digest_size":
+				if val, err := strconv.Atoi(v[0]); err == nil && val > 0 {
+					global.Settings.Curation.DigestSize = val
+				}
+			case "curation_zap_sats_per_point":
+				if val, err := strconv.Atoi(v[0]); err == nil && val >= 0 {
+					global.Settings.Curation.ZapSatsPerPoint = val
+				}
+			case "curation_half_life_hours", "curation_reaction_weight", "curation_emoji_weight",
+				"curation_repost_weight", "curation_reply_weight", "curation_highlight_weight",
+				"curation_zap_weight", "curation_negative_weight", "curation_level_weight", "curation_max_per_member":
+				val, err := strconv.ParseFloat(v[0], 64)
+				if err != nil {
+					http.Error(w, "invalid "+k+": "+err.Error(), 400)
+					return
+				}
+				switch k {
+				case "curation_half_life_hours":
+					global.Settings.Curation.HalfLifeHours = max(0, val)
+				case "curation_reaction_weight":
+					global.Settings.Curation.ReactionWeight = val
+				case "curation_emoji_weight":
+					global.Settings.Curation.EmojiWeight = val
+				case "curation_repost_weight":
+					global.Settings.Curation.RepostWeight = val
+				case "curation_reply_weight":
+					global.Settings.Curation.ReplyWeight = val
+				case "curation_highlight_weight":
+					global.Settings.Curation.HighlightWeight = val
+				case "curation_zap_weight":
+					global.Settings.Curation.ZapWeight = val
+				case "curation_negative_weight":
+					global.Settings.Curation.NegativeWeight = val
+				case "curation_level_weight":
+					global.Settings.Curation.LevelWeight = val
+				case "curation_max_per_member":
+					global.Settings.Curation.MaxPerMember = max(0, val)
+				}
+				//
 				// allowed kinds settings
 			case "allowed_kinds_spec":
 				kindIsAllowed, err := global.BuildKindIsAllowedFunction(v[0], global.SupportedKindsDefault)
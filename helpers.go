@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -48,6 +50,19 @@ func parsePubKey(value string) (nostr.PubKey, error) {
 	return nostr.PubKey{}, fmt.Errorf("invalid pubkey (\"%s\"): expected hex, npub, or nprofile", value)
 }
 
+func onlyPinnedFields(r *http.Request) bool {
+	r.ParseForm()
+	if len(r.PostForm) == 0 {
+		return false
+	}
+	for k := range r.PostForm {
+		if !strings.HasSuffix(k, "_pinned") {
+			return false
+		}
+	}
+	return true
+}
+
 func checkPinnedID(str string, store *mmm.IndexingLayer) nostr.ID {
 	id, err := nostr.IDFromHex(str)
 	if err != nil {
@@ -91,3 +106,19 @@ func normalizeDomainInput(domain string) (string, error) {
 
 	return domain, nil
 }
+
+// parsePerLevel reads a limit typed either as a single number or as slash-separated values
+// for each pyramid level (e.g. "100/50/25").
+func parsePerLevel(input string) (single int, levels []int) {
+	if !strings.Contains(input, "/") {
+		single, _ = strconv.Atoi(strings.TrimSpace(input))
+		return single, nil
+	}
+
+	for _, p := range strings.Split(input, "/") {
+		if n, err := strconv.Atoi(strings.TrimSpace(p)); err == nil {
+			levels = append(levels, n)
+		}
+	}
+	return 0, levels
+}
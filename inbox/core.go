@@ -11,6 +11,7 @@ import (
 	"fiatjaf.com/nostr/nip13"
 	"fiatjaf.com/nostr/nip61"
 	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/paywall"
 	"github.com/fiatjaf/pyramid/pyramid"
 	"github.com/fiatjaf/pyramid/wot"
 )
@@ -215,6 +216,9 @@ func rejectEvent(ctx context.Context, evt nostr.Event) (bool, string) {
 		}
 
 		receiver, _ := nostr.PubKeyFromHex(evt.Tags.Find("p")[1])
+		if paywall.PaymentTooFarAhead(evt) {
+			return true, "invalid: payment is dated in the future"
+		}
 		switch evt.Kind {
 		case 9735:
 			// check zap validity
@@ -250,6 +254,13 @@ func rejectEvent(ctx context.Context, evt nostr.Event) (bool, string) {
 			if amount, ok := nip61.VerifyNutzap(ksKeys, evt); !ok || amount == 0 {
 				return true, "invalid nutzap"
 			}
+			if paywall.ProofsAlreadyUsed(evt) {
+				return true, "duplicate: these proofs were already sent"
+			}
+			if global.Settings.Paywall.Enabled && paywall.HasOffers(receiver) {
+				// anyone can pay for access to a member's paywall
+				return false, ""
+			}
 		default:
 			return true, "unexpected money kind"
 		}
@@ -62,7 +62,7 @@ func listBannedPubkeysHandler(ctx context.Context) ([]nip86.PubKeyReason, error)
 		return nil, fmt.Errorf("not authenticated")
 	}
 
-	if !pyramid.IsRoot(author) {
+	if !pyramid.HasCapability(author, pyramid.CapInboxBans) {
 		return nil, fmt.Errorf("unauthorized")
 	}
 
@@ -82,7 +82,7 @@ func banPubkeyHandler(ctx context.Context, pubkey nostr.PubKey, reason string) e
 		return fmt.Errorf("not authenticated")
 	}
 
-	if !pyramid.IsRoot(author) {
+	if !pyramid.HasCapability(author, pyramid.CapInboxBans) {
 		return fmt.Errorf("unauthorized")
 	}
 
@@ -103,7 +103,7 @@ func allowPubkeyHandler(ctx context.Context, pubkey nostr.PubKey, reason string)
 		return fmt.Errorf("not authenticated")
 	}
 
-	if !pyramid.IsRoot(author) {
+	if !pyramid.HasCapability(author, pyramid.CapInboxBans) {
 		return fmt.Errorf("unauthorized")
 	}
 
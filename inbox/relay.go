@@ -16,6 +16,7 @@ import (
 	"fiatjaf.com/nostr/nip45/hyperloglog"
 
 	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/paywall"
 	"github.com/fiatjaf/pyramid/pyramid"
 	"github.com/fiatjaf/pyramid/wot"
 )
@@ -136,6 +137,12 @@ func setupEnabled() {
 		return nil
 	}
 	Relay.StartExpirationManager(Relay.QueryStored, Relay.DeleteEvent, nil)
+	Relay.OnEventSaved = func(ctx context.Context, event nostr.Event) {
+		if event.Kind == 9735 || event.Kind == 9321 {
+			// payments may buy access to a member's paywall
+			paywall.ProcessPayment(ctx, event)
+		}
+	}
 
 	pk := global.Settings.RelayInternalSecretKey.Public()
 	Relay.Info.Self = &pk
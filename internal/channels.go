@@ -0,0 +1,248 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/khatru"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+// events are put in a channel with an "h" tag having its id, events without it are seen by all members.
+// events in channels that don't exist anymore are only seen by root.
+const channelTag = "h"
+
+func findChannel(id string) (global.InternalChannel, bool) {
+	idx := slices.IndexFunc(global.Settings.Internal.Channels, func(c global.InternalChannel) bool { return c.ID == id })
+	if idx == -1 {
+		return global.InternalChannel{}, false
+	}
+	return global.Settings.Internal.Channels[idx], true
+}
+
+func canSeeChannel(channel global.InternalChannel, pubkey nostr.PubKey) bool {
+	if pyramid.IsRoot(pubkey) {
+		return true
+	}
+	if !pyramid.IsMember(pubkey) {
+		return false
+	}
+	if channel.Role != "" && !pyramid.MemberHasRole(pubkey, channel.Role) {
+		return false
+	}
+	if channel.Subtree != nostr.ZeroPK && channel.Subtree != pubkey && !pyramid.IsAncestorOf(channel.Subtree, pubkey) {
+		return false
+	}
+	return true
+}
+
+// channelsFor lists the channels a member can see.
+func channelsFor(pubkey nostr.PubKey) []global.InternalChannel {
+	return slices.DeleteFunc(slices.Clone(global.Settings.Internal.Channels), func(c global.InternalChannel) bool {
+		return !canSeeChannel(c, pubkey)
+	})
+}
+
+func eventChannel(evt nostr.Event) (string, bool) {
+	tag := evt.Tags.Find(channelTag)
+	if tag == nil {
+		return "", false
+	}
+	return tag[1], true
+}
+
+// canSeeEvent tells if any of the authed keys is allowed in the channel of an event.
+func canSeeEvent(evt nostr.Event, authed []nostr.PubKey) bool {
+	id, inChannel := eventChannel(evt)
+	if !inChannel {
+		return slices.ContainsFunc(authed, pyramid.IsMember)
+	}
+
+	channel, exists := findChannel(id)
+	for _, pubkey := range authed {
+		if exists && canSeeChannel(channel, pubkey) || !exists && pyramid.IsRoot(pubkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryVisible is QueryStoredWithPinned, but only with the events in channels the reader can see.
+func queryVisible() func(ctx context.Context, filter nostr.Filter) iter.Seq[nostr.Event] {
+	queryStored := global.QueryStoredWithPinned(global.RelayInternal)
+	return func(ctx context.Context, filter nostr.Filter) iter.Seq[nostr.Event] {
+		return func(yield func(nostr.Event) bool) {
+			authed := khatru.GetAllAuthed(ctx)
+			limit := global.Settings.Limits.MaxQueryLimit
+			if filter.Limit > 0 && filter.Limit < limit {
+				limit = filter.Limit
+			}
+
+			// some events may be hidden, so we don't let the store stop before we have enough
+			filter.Limit = 0
+			count := 0
+			for evt := range queryStored(ctx, filter) {
+				if !canSeeEvent(evt, authed) {
+					continue
+				}
+				// the pinned note may come wrapped in a repost made by the relay that doesn't carry its channel
+				if pinned := global.PinnedCache.Internal; pinned != nil &&
+					isPinnedRepost(evt, *pinned) && !canSeeEvent(*pinned, authed) {
+					continue
+				}
+				if !yield(evt) {
+					return
+				}
+				count++
+				if count >= limit {
+					return
+				}
+			}
+		}
+	}
+}
+
+// isPinnedRepost tells if an event is the repost global.PreparedPinned() wraps the pinned note in.
+func isPinnedRepost(evt nostr.Event, pinned nostr.Event) bool {
+	return (evt.Kind == 6 || evt.Kind == 16) &&
+		evt.PubKey == global.Settings.RelayInternalSecretKey.Public() &&
+		evt.Tags.FindWithValue("e", pinned.ID.Hex()) != nil
+}
+
+// countVisible is like the store's count, but doesn't count what the reader can't see.
+// it goes through at most as many events as a query could return, so counts stop there.
+func countVisible(ctx context.Context, filter nostr.Filter) (uint32, error) {
+	authed := khatru.GetAllAuthed(ctx)
+	var count uint32
+	for evt := range global.IL.Internal.QueryEvents(filter, global.Settings.Limits.MaxQueryLimit) {
+		if canSeeEvent(evt, authed) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func preventBroadcast(ws *khatru.WebSocket, filter nostr.Filter, evt nostr.Event) bool {
+	return !canSeeEvent(evt, ws.AuthedPublicKeys)
+}
+
+// rejectOutsideChannel makes sure members only publish to channels that exist and that they can see.
+func rejectOutsideChannel(ctx context.Context, evt nostr.Event) (bool, string) {
+	id, inChannel := eventChannel(evt)
+	if !inChannel {
+		return false, ""
+	}
+
+	channel, exists := findChannel(id)
+	if !exists {
+		return true, "invalid: channel " + id + " doesn't exist"
+	}
+	if !canSeeChannel(channel, evt.PubKey) {
+		return true, "restricted: you're not allowed in channel " + id
+	}
+	return false, ""
+}
+
+func channelID(name string) string {
+	base := strings.Trim(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, name), "-")
+	if base == "" {
+		base = "channel"
+	}
+
+	id := base
+	for i := 2; ; i++ {
+		if _, exists := findChannel(id); !exists {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// channelsHandler creates a channel or changes who can see it.
+func channelsHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsRoot(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	role := r.PostFormValue("role")
+	if role != "" {
+		if _, ok := pyramid.Roles.Load(role); !ok {
+			http.Error(w, "unknown role", 400)
+			return
+		}
+	}
+	var subtree nostr.PubKey
+	if input := strings.TrimSpace(r.PostFormValue("subtree")); input != "" {
+		subtree = global.PubKeyFromInput(input)
+		if !pyramid.IsMember(subtree) {
+			http.Error(w, "the subtree must start at a relay member", 400)
+			return
+		}
+	}
+	description := strings.TrimSpace(r.PostFormValue("description"))
+
+	if id := r.PostFormValue("id"); id != "" {
+		idx := slices.IndexFunc(global.Settings.Internal.Channels, func(c global.InternalChannel) bool { return c.ID == id })
+		if idx == -1 {
+			http.Error(w, "channel not found", 404)
+			return
+		}
+		global.Settings.Internal.Channels[idx].Description = description
+		global.Settings.Internal.Channels[idx].Role = role
+		global.Settings.Internal.Channels[idx].Subtree = subtree
+	} else {
+		name := strings.TrimSpace(r.PostFormValue("name"))
+		if name == "" {
+			http.Error(w, "a channel needs a name", 400)
+			return
+		}
+		channel := global.InternalChannel{
+			ID:          channelID(name),
+			Name:        name,
+			Description: description,
+			Role:        role,
+			Subtree:     subtree,
+		}
+		global.Settings.Internal.Channels = append(global.Settings.Internal.Channels, channel)
+		log.Info().Str("channel", channel.ID).Msg("internal channel created")
+	}
+
+	if err := global.SaveUserSettings(); err != nil {
+		http.Error(w, "failed to save settings: "+err.Error(), 500)
+		return
+	}
+	http.Redirect(w, r, global.Settings.Internal.GetPageURL(), 302)
+}
+
+// deleteChannelHandler removes a channel, after which its events are only seen by root.
+func deleteChannelHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsRoot(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	id := r.PostFormValue("id")
+	global.Settings.Internal.Channels = slices.DeleteFunc(global.Settings.Internal.Channels, func(c global.InternalChannel) bool { return c.ID == id })
+	if err := global.SaveUserSettings(); err != nil {
+		http.Error(w, "failed to save settings: "+err.Error(), 500)
+		return
+	}
+	log.Info().Str("channel", id).Msg("internal channel deleted")
+	http.Redirect(w, r, global.Settings.Internal.GetPageURL(), 302)
+}
@@ -66,8 +66,10 @@ func setupEnabled() {
 
 	Relay.UseEventstore(db, global.Settings.Limits.MaxQueryLimit)
 
-	// use custom QueryStored with pinned event support
-	Relay.QueryStored = global.QueryStoredWithPinned(global.RelayInternal)
+	// use custom QueryStored with pinned event support, hiding channels the reader isn't in
+	Relay.QueryStored = queryVisible()
+	Relay.Count = countVisible
+	Relay.PreventBroadcast = preventBroadcast
 
 	pk := global.Settings.RelayInternalSecretKey.Public()
 	Relay.Info.Self = &pk
@@ -108,11 +110,15 @@ func setupEnabled() {
 				return true, "blocked: kind unallowed"
 			}
 
-			if pyramid.IsMember(evt.PubKey) {
+			if pyramid.MemberMay(evt.PubKey, pyramid.CapInternal) {
 				return false, ""
 			}
+			if pyramid.IsMember(evt.PubKey) {
+				return true, "restricted: publishing here is limited to some roles"
+			}
 			return true, "restricted: must be a relay member"
 		},
+		rejectOutsideChannel,
 	)
 
 	mux := http.NewServeMux()
@@ -121,6 +127,8 @@ func setupEnabled() {
 		internalPage(loggedUser).Render(r.Context(), w)
 	})
 	mux.HandleFunc("POST /"+global.Settings.Internal.HTTPBasePath+"/disable", disableHandler)
+	mux.HandleFunc("POST /"+global.Settings.Internal.HTTPBasePath+"/channels", channelsHandler)
+	mux.HandleFunc("POST /"+global.Settings.Internal.HTTPBasePath+"/channels/delete", deleteChannelHandler)
 	Relay.SetRouter(mux)
 }
 
@@ -0,0 +1,26 @@
+package layout
+
+import (
+	"github.com/fiatjaf/pyramid/global"
+)
+
+// feedOf tells where the feeds of the relay a page belongs to are served (without the "feed.xml" or
+// "feed.json" part), and their title. pages of relays that don't have feeds get an empty base.
+func feedOf(currentPage string) (base string, title string) {
+	var metadata global.RelayMetadata
+	switch currentPage {
+	case "invite-tree", "member page", "stats":
+		return "/", global.Settings.RelayName
+	case "popular":
+		metadata = global.Settings.Popular.RelayMetadata
+	case "uppermost":
+		metadata = global.Settings.Uppermost.RelayMetadata
+	case "moderated":
+		metadata = global.Settings.Moderated.RelayMetadata
+	case "favorites":
+		metadata = global.Settings.Favorites.RelayMetadata
+	default:
+		return "", ""
+	}
+	return "/" + metadata.HTTPBasePath + "/", metadata.GetName()
+}
@@ -11,7 +11,6 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"time"
 
 	"fiatjaf.com/nostr"
 	"github.com/fiatjaf/pyramid/global"
@@ -24,7 +23,7 @@ var (
 	log     = global.Log.With().Str("service", "linkpreview").Logger()
 	Handler = &MuxHandler{}
 
-	client = &http.Client{Timeout: 10 * time.Second}
+	client = newClient()
 
 	baseSecret []byte
 )
@@ -115,7 +114,7 @@ func previewHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := client.Get(req.URL)
+	resp, err := Fetch(req.URL)
 	if err != nil {
 		http.Error(w, "failed to fetch url", http.StatusBadGateway)
 		return
@@ -123,7 +122,7 @@ func previewHandler(w http.ResponseWriter, r *http.Request) {
 	defer resp.Body.Close()
 
 	preview := Preview{URL: req.URL}
-	parseOG(resp.Body, &preview)
+	parseOG(io.LimitReader(resp.Body, maxPageSize), &preview)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(preview)
@@ -0,0 +1,129 @@
+package linkpreview
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	maxPageSize     = 5 << 20
+	maxSnapshotText = 200_000
+)
+
+// Snapshot is a readable copy of a web page: its metadata and the text of its main content.
+type Snapshot struct {
+	Preview
+	Text string
+}
+
+// Fetch requests a web page with the same client used for previews, which only reaches public addresses.
+func Fetch(rawURL string) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be http(s)")
+	}
+	return client.Get(u.String())
+}
+
+// TakeSnapshot fetches a web page and keeps its metadata and the text of its main content.
+func TakeSnapshot(url string) (Snapshot, error) {
+	resp, err := Fetch(url)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return Snapshot{}, fmt.Errorf("got status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	snapshot := Snapshot{Preview: Preview{URL: url}}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		snapshot.Text = string(body)
+	} else {
+		parseOG(bytes.NewReader(body), &snapshot.Preview)
+		snapshot.Text = extractText(bytes.NewReader(body))
+	}
+
+	if len(snapshot.Text) > maxSnapshotText {
+		snapshot.Text = strings.ToValidUTF8(snapshot.Text[0:maxSnapshotText], "") + "…"
+	}
+	return snapshot, nil
+}
+
+// extractText keeps the paragraphs of the main content of a page, leaving menus, scripts and such out.
+func extractText(r io.Reader) string {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return ""
+	}
+
+	// prefer what the page says is its main content
+	var main func(*html.Node) *html.Node
+	main = func(n *html.Node) *html.Node {
+		if n.Type == html.ElementNode && (n.Data == "article" || n.Data == "main") {
+			return n
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if found := main(c); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	root := main(doc)
+	if root == nil {
+		root = doc
+	}
+
+	paragraphs := make([]string, 0, 50)
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "script", "style", "noscript", "nav", "header", "footer", "aside", "form", "svg", "iframe":
+				return
+			case "p", "h1", "h2", "h3", "h4", "h5", "h6", "li", "blockquote", "pre", "figcaption", "dt", "dd":
+				var b strings.Builder
+				collectText(n, &b)
+				text := b.String()
+				if n.Data != "pre" {
+					text = strings.Join(strings.Fields(text), " ")
+				}
+				if text = strings.TrimSpace(text); text != "" {
+					paragraphs = append(paragraphs, text)
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(root)
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func collectText(n *html.Node, b *strings.Builder) {
+	if n.Type == html.TextNode {
+		b.WriteString(n.Data)
+		return
+	}
+	if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style") {
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		collectText(c, b)
+	}
+}
@@ -62,6 +62,11 @@ var (
 var static embed.FS
 
 func main() {
+	if len(os.Args) > 1 && slices.Contains(commands, os.Args[1]) {
+		os.Exit(runCommand(os.Args[1:]))
+		return
+	}
+
 	if err := global.Init(); err != nil {
 		fmt.Fprintf(os.Stderr, "couldn't initialize: %s\n", err)
 		os.Exit(7)
@@ -120,6 +125,24 @@ func main() {
 		return
 	}
 
+	// disable members whose time-limited memberships have expired
+	go func() {
+		for {
+			time.Sleep(time.Minute * 5)
+			disabled, err := pyramid.DisableExpiredMembers()
+			if err != nil {
+				log.Error().Err(err).Msg("failed to disable expired members")
+			}
+			for _, pubkey := range disabled {
+				log.Info().Str("member", pubkey.Hex()).Msg("membership expired")
+				publishMembershipChange(pubkey, false)
+			}
+		}
+	}()
+
+	// re-score and expire events in popular and uppermost
+	go runCurator()
+
 	// init main relay
 	relay = global.NewRelay()
 	relays.MainRelay = relay
@@ -129,6 +152,9 @@ func main() {
 
 	// cache pinned event at startup
 	global.CachePinnedEvent(global.RelayMain)
+	loadContentWarningLabels()
+	loadReportDecisions()
+	loadHiddenByReports()
 
 	// init sdk
 	global.Nostr = sdk.NewSystem()
@@ -147,6 +173,9 @@ func main() {
 	relay.Router().HandleFunc("POST /action", actionHandler)
 	relay.Router().HandleFunc("GET /settings", settingsHandler)
 	relay.Router().HandleFunc("POST /settings", settingsHandler)
+	relay.Router().HandleFunc("POST /roles/capabilities", roleCapabilitiesHandler)
+	relay.Router().HandleFunc("POST /members/import", membersImportHandler)
+	relay.Router().HandleFunc("GET /members/export", membersExportHandler)
 	relay.Router().HandleFunc("GET /clients", detailsHandler)
 	relay.Router().HandleFunc("GET /clients/{clientId}", clientDetailsHandler)
 	relay.Router().HandleFunc("GET /event/{db}/{id}", databaseEventJSONHandler)
@@ -156,6 +185,7 @@ func main() {
 	relay.Router().HandleFunc("GET /database/blocks", databaseBlocksHandler)
 	relay.Router().HandleFunc("POST /database/blocks/defrag", databaseBlocksDefragHandler)
 	relay.Router().HandleFunc("GET /log", logHandler)
+	relay.Router().HandleFunc("GET /management.jsonl", managementLogHandler)
 	relay.Router().HandleFunc("/search/reindex", search.StreamingReindexHTML)
 	relay.Router().HandleFunc("GET /u", memberPageHandler)
 	relay.Router().HandleFunc("POST /u", memberPageHandler)
@@ -163,6 +193,12 @@ func main() {
 	relay.Router().HandleFunc("GET /u/sync", syncHandler)
 	relay.Router().HandleFunc("POST /u/sync", syncHandler)
 	relay.Router().HandleFunc("GET /stats", statsHandler)
+	relay.Router().HandleFunc("GET /analytics", inviteTreeAnalyticsHandler)
+	relay.Router().HandleFunc("GET /reports", reportsHandler)
+	relay.Router().HandleFunc("POST /reports/decide", reportDecisionHandler)
+	relay.Router().HandleFunc("GET /curation", curationLogHandler)
+	relay.Router().HandleFunc("GET /feed.xml", mainFeedXMLHandler)
+	relay.Router().HandleFunc("GET /feed.json", mainFeedJSONHandler)
 	relay.Router().HandleFunc("/update", updateHandler)
 	relay.Router().HandleFunc("/restart", restartHandler)
 	relay.Router().HandleFunc("/icon/{relayId}", iconHandler)
@@ -340,16 +376,31 @@ func main() {
 
 		// normal logic
 		switch event.Kind {
-		case 6, 7, 9321, 9735, 9802, 1, 1111, 1244:
+		case 6, 7, 16, 9321, 9735, 9802, 1, 1111, 1244:
 			processReactions(ctx, event)
 		case 0, 3, 10019:
 			global.IL.System.ReplaceEvent(event)
+		case 1984:
+			processReport(ctx, event)
+		case 1985:
+			registerContentWarningLabel(event)
 		case 1163:
 			// NIP-63 paywall event - already handled in basicRejectionLogic
 			// recompute user paywall to ensure consistency
 			paywall.RecomputeMemberPaywall(ctx, event.PubKey)
 		}
 
+		if event.Tags.Has("nip63") {
+			paywall.ForgetPaywalledEvents(event.PubKey)
+		}
+
+		// readers without access to a paywalled event get a teaser of it instead
+		if global.Settings.Paywall.Enabled && event.Tags.Has("nip63") && event.CreatedAt <= nostr.Now()+60 {
+			if teaser, ok := paywall.Teaser(event); ok {
+				relay.BroadcastEvent(teaser)
+			}
+		}
+
 		// any replaceable event can potentially be referenced by a paywall
 		if event.Kind.IsReplaceable() || event.Kind.IsAddressable() {
 			for by := range paywall.ReferencedBy(event) {
@@ -391,6 +442,7 @@ func main() {
 	relay.ManagementAPI.DeleteRole = deleteRoleHandler
 	relay.ManagementAPI.AssignRole = assignRoleHandler
 	relay.ManagementAPI.UnassignRole = unassignRoleHandler
+	relay.ManagementAPI.Generic = genericManagementHandler
 	relay.OverwriteRelayInformation = func(ctx context.Context, r *http.Request, info nip11.RelayInformationDocument) nip11.RelayInformationDocument {
 		// prevent flotilla from doing its negentropy here as it is incompatible with our groups approach
 		if strings.Contains(r.Header.Get("User-Agent"), "aiohttp") || strings.Contains(r.Referer(), "flotilla") {
@@ -496,6 +548,7 @@ func run(ctx context.Context) error {
 	mux.Handle("/"+global.Settings.Favorites.HTTPBasePath+"/", favorites.Relay)
 	mux.Handle("/"+global.Settings.Favorites.HTTPBasePath, favorites.Relay)
 
+	mux.Handle("/"+global.Settings.Bookmarks.HTTPBasePath+"/team/", bookmarks.TeamsHandler)
 	mux.Handle("/"+global.Settings.Bookmarks.HTTPBasePath+"/all/", bookmarks.AllRelay)
 	mux.Handle("/"+global.Settings.Bookmarks.HTTPBasePath+"/all", bookmarks.AllRelay)
 	mux.Handle("/"+global.Settings.Bookmarks.HTTPBasePath+"/", bookmarks.Relay)
@@ -591,6 +644,8 @@ func run(ctx context.Context) error {
 		case global.Settings.Bookmarks.HTTPDomain:
 			if strings.HasPrefix(r.URL.Path, "/all") {
 				subRelay, basePath = bookmarks.AllRelay, global.Settings.Bookmarks.HTTPBasePath+"/all"
+			} else if teamRelay, teamPath, ok := bookmarks.TeamRelayAt(r.URL.Path); ok && global.Settings.Bookmarks.Enabled {
+				subRelay, basePath = teamRelay, teamPath
 			} else {
 				subRelay, basePath = bookmarks.Relay, global.Settings.Bookmarks.HTTPBasePath
 			}
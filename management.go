@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"slices"
 	"strconv"
@@ -46,6 +47,102 @@ func banPubKeyHandler(ctx context.Context, pubkey nostr.PubKey, reason string) e
 	return err
 }
 
+// genericManagementHandler dispatches the non-standard NIP-86 methods pyramid supports
+func genericManagementHandler(ctx context.Context, request nip86.Request) (nip86.Response, error) {
+	caller, ok := khatru.GetAuthed(ctx)
+	if !ok {
+		return nip86.Response{}, fmt.Errorf("not authenticated")
+	}
+
+	switch request.Method {
+	case "listpendingdrops", "approvedrop", "canceldrop":
+		return pendingDropsHandler(caller, request)
+	case "setrolecapabilities":
+		return setRoleCapabilitiesHandler(caller, request)
+	case "importmembers", "exportmembers":
+		return bulkMembersHandler(caller, request)
+	default:
+		return nip86.Response{}, fmt.Errorf("method '%s' not known", request.Method)
+	}
+}
+
+// pendingDropsHandler implements "listpendingdrops", "approvedrop" and "canceldrop" (the last two take the target pubkey).
+func pendingDropsHandler(caller nostr.PubKey, request nip86.Request) (nip86.Response, error) {
+	switch request.Method {
+	case "listpendingdrops":
+		if !pyramid.IsMember(caller) {
+			return nip86.Response{}, fmt.Errorf("only members can list pending drops")
+		}
+		type pendingDrop struct {
+			PubKey    nostr.PubKey    `json:"pubkey"`
+			Proposer  nostr.PubKey    `json:"proposer"`
+			Approvals []nostr.PubKey  `json:"approvals"`
+			Required  int             `json:"required"`
+			CreatedAt nostr.Timestamp `json:"created_at"`
+		}
+		list := make([]pendingDrop, 0, pyramid.DropProposals.Size())
+		for target, proposal := range pyramid.DropProposals.Range {
+			list = append(list, pendingDrop{
+				PubKey:    target,
+				Proposer:  proposal.Proposer,
+				Approvals: proposal.Approvals,
+				Required:  pyramid.RequiredDropApprovals(target),
+				CreatedAt: proposal.CreatedAt,
+			})
+		}
+		return nip86.Response{Result: list}, nil
+	case "approvedrop", "canceldrop":
+		if len(request.Params) == 0 {
+			return nip86.Response{}, fmt.Errorf("missing pubkey param")
+		}
+		param, _ := request.Params[0].(string)
+		target, err := nostr.PubKeyFromHex(param)
+		if err != nil {
+			return nip86.Response{}, fmt.Errorf("invalid pubkey: %w", err)
+		}
+		log.Info().Str("caller", caller.Hex()).Str("pubkey", target.Hex()).Msg("management " + request.Method + " called")
+
+		if request.Method == "canceldrop" {
+			if err := pyramid.CancelDrop(caller, target); err != nil {
+				return nip86.Response{}, err
+			}
+			return nip86.Response{Result: true}, nil
+		}
+
+		if err := pyramid.ApproveDrop(caller, target); errors.Is(err, pyramid.ErrDropPending) {
+			// the approval was registered, but more are needed
+			return nip86.Response{Result: err.Error()}, nil
+		} else if err != nil {
+			return nip86.Response{}, err
+		}
+		publishMembershipChange(target, false)
+		return nip86.Response{Result: true}, nil
+	}
+	return nip86.Response{}, nil
+}
+
+// setRoleCapabilitiesHandler takes a role id followed by the full list of capabilities that role should have.
+func setRoleCapabilitiesHandler(caller nostr.PubKey, request nip86.Request) (nip86.Response, error) {
+	if len(request.Params) < 1 {
+		return nip86.Response{}, fmt.Errorf("missing role id param")
+	}
+	roleID, _ := request.Params[0].(string)
+
+	capabilities := make([]string, 0, len(request.Params)-1)
+	for _, param := range request.Params[1:] {
+		if capability, ok := param.(string); ok {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	log.Info().Str("caller", caller.Hex()).Str("role", roleID).Strs("capabilities", capabilities).Msg("management setrolecapabilities called")
+
+	if err := pyramid.SetRoleCapabilitiesAction(caller, roleID, capabilities); err != nil {
+		return nip86.Response{}, err
+	}
+	go publishRoleDefinition(roleID)
+	return nip86.Response{Result: true}, nil
+}
+
 func listAllowedPubKeysHandler(ctx context.Context) ([]nip86.PubKeyReason, error) {
 	log.Info().Msg("management listallowedpubkeys called")
 	list := make([]nip86.PubKeyReason, 0, pyramid.Members.Size())
@@ -356,3 +453,34 @@ func disallowKindHandler(ctx context.Context, kind nostr.Kind) error {
 
 	return global.SaveUserSettings()
 }
+
+// bulkMembersHandler implements "importmembers", which takes a csv or a list event json, an optional
+// default parent and an optional boolean to apply the import (otherwise only the dry-run result is returned),
+// and "exportmembers", which returns all the parent links in the tree.
+func bulkMembersHandler(caller nostr.PubKey, request nip86.Request) (nip86.Response, error) {
+	if request.Method == "exportmembers" {
+		if !pyramid.IsMember(caller) {
+			return nip86.Response{}, fmt.Errorf("only members can export the member list")
+		}
+		return nip86.Response{Result: toBulkEntriesJSON(pyramid.ExportMembers())}, nil
+	}
+
+	if len(request.Params) == 0 {
+		return nip86.Response{}, fmt.Errorf("missing input param")
+	}
+	input, _ := request.Params[0].(string)
+	var defaultParent string
+	if len(request.Params) > 1 {
+		defaultParent, _ = request.Params[1].(string)
+	}
+	apply := false
+	if len(request.Params) > 2 {
+		apply, _ = request.Params[2].(bool)
+	}
+
+	result, err := importMembers(caller, input, defaultParent, !apply)
+	if err != nil {
+		return nip86.Response{}, err
+	}
+	return nip86.Response{Result: result}, nil
+}
@@ -61,8 +61,8 @@ func rejectEvent(rejector nostr.PubKey, id nostr.ID) error {
 func approveHandler(w http.ResponseWriter, r *http.Request) {
 	loggedUser, _ := global.GetLoggedUser(r)
 
-	if !pyramid.IsMember(loggedUser) {
-		http.Error(w, "unauthorized: must be a member", 403)
+	if !pyramid.MemberMay(loggedUser, pyramid.CapModerate) {
+		http.Error(w, "unauthorized: must be a member with moderation rights", 403)
 		return
 	}
 
@@ -83,8 +83,8 @@ func approveHandler(w http.ResponseWriter, r *http.Request) {
 func rejectHandler(w http.ResponseWriter, r *http.Request) {
 	loggedUser, _ := global.GetLoggedUser(r)
 
-	if !pyramid.IsMember(loggedUser) {
-		http.Error(w, "unauthorized: must be a member", 403)
+	if !pyramid.MemberMay(loggedUser, pyramid.CapModerate) {
+		http.Error(w, "unauthorized: must be a member with moderation rights", 403)
 		return
 	}
 
@@ -108,7 +108,7 @@ func listEventsNeedingModerationHandler(ctx context.Context) ([]nip86.IDReason,
 		return nil, fmt.Errorf("not authenticated")
 	}
 
-	if !pyramid.IsMember(author) {
+	if !pyramid.MemberMay(author, pyramid.CapModerate) {
 		return nil, fmt.Errorf("unauthorized")
 	}
 
@@ -125,7 +125,7 @@ func allowEventHandler(ctx context.Context, id nostr.ID, reason string) error {
 		return fmt.Errorf("not authenticated")
 	}
 
-	if !pyramid.IsMember(author) {
+	if !pyramid.MemberMay(author, pyramid.CapModerate) {
 		return fmt.Errorf("unauthorized")
 	}
 
@@ -138,8 +138,8 @@ func banEventHandler(ctx context.Context, id nostr.ID, reason string) error {
 		return fmt.Errorf("not authenticated")
 	}
 
-	// allow if caller is a member (includes root users)
-	if pyramid.IsMember(caller) {
+	// allow if caller is a member that can moderate (includes root users)
+	if pyramid.MemberMay(caller, pyramid.CapModerate) {
 		log.Info().Str("caller", caller.Hex()).Str("id", id.Hex()).Str("reason", reason).Msg("moderated banevent called by member")
 	} else {
 		// check if the caller is the author of the event being banned
@@ -12,6 +12,7 @@ import (
 	"fiatjaf.com/nostr/nip11"
 	"fiatjaf.com/nostr/nip13"
 
+	"github.com/fiatjaf/pyramid/feed"
 	"github.com/fiatjaf/pyramid/global"
 	"github.com/fiatjaf/pyramid/pyramid"
 )
@@ -120,6 +121,7 @@ func setupEnabled() {
 	mux.HandleFunc("POST /"+global.Settings.Moderated.HTTPBasePath+"/approve/{eventId}", approveHandler)
 	mux.HandleFunc("POST /"+global.Settings.Moderated.HTTPBasePath+"/reject/{eventId}", rejectHandler)
 	mux.HandleFunc("POST /"+global.Settings.Moderated.HTTPBasePath+"/disable", disableHandler)
+	feed.Register(mux, func() global.RelayMetadata { return global.Settings.Moderated.RelayMetadata }, global.IL.Moderated)
 	mux.HandleFunc("/"+global.Settings.Moderated.HTTPBasePath+"/", moderatedPageHandler)
 	Relay.SetRouter(mux)
 }
@@ -0,0 +1,221 @@
+package paywall
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"fiatjaf.com/nostr"
+	"github.com/puzpuzpuz/xsync/v3"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+// Reader is one grant in a member's paywall, as shown in the dashboard and in the export.
+type Reader struct {
+	PubKey  nostr.PubKey    `json:"pubkey"`
+	Tier    string          `json:"tier"`
+	Source  string          `json:"source"` // "direct", "reference" or "payment"
+	Event   nostr.ID        `json:"event"`  // the kind:1163, the referenced list or the payment that granted access
+	Granted nostr.Timestamp `json:"granted_at"`
+	Expires nostr.Timestamp `json:"expires_at,omitempty"`
+	Expired bool            `json:"expired,omitempty"`
+	Sats    uint64          `json:"sats,omitempty"`
+	Comment string          `json:"comment,omitempty"`
+}
+
+// ReferencedList is a list a member referenced with an "a" tag, whose "p" tags are readers.
+type ReferencedList struct {
+	Address string `json:"address"`
+	Found   bool   `json:"found"` // if the list is stored in this relay
+	Readers int    `json:"readers"`
+}
+
+// MemberOverview is everything about the paywall of one member.
+type MemberOverview struct {
+	Member          nostr.PubKey     `json:"member"`
+	PaywalledEvents map[string]int   `json:"paywalled_events"` // by tier
+	Readers         []Reader         `json:"readers"`
+	References      []ReferencedList `json:"referenced_lists"`
+	Offers          []Offer          `json:"offers"`
+	Revenue         uint64           `json:"revenue_sats"` // paid in the last revenuePeriod days
+}
+
+const revenuePeriod = 30
+
+// the root view goes through every member, so their overviews are kept for a while
+const overviewCacheDuration = 5 * 60
+
+type cachedOverview struct {
+	overview MemberOverview
+	at       nostr.Timestamp
+}
+
+var overviewCache = xsync.NewMapOf[nostr.PubKey, cachedOverview]()
+
+// counting paywalled events means going through everything a member published, so that is only
+// done again after they publish or delete a paywalled event, or once in a while to catch replacements
+const paywalledCountDuration = 60 * 60
+
+type cachedPaywalledCount struct {
+	byTier map[string]int
+	at     nostr.Timestamp
+}
+
+var paywalledCounts = xsync.NewMapOf[nostr.PubKey, cachedPaywalledCount]()
+
+func countPaywalledEvents(member nostr.PubKey) map[string]int {
+	now := nostr.Now()
+	if cached, ok := paywalledCounts.Load(member); ok && cached.at+paywalledCountDuration > now {
+		return maps.Clone(cached.byTier)
+	}
+
+	byTier := make(map[string]int)
+	for evt := range global.IL.Main.QueryEvents(nostr.Filter{Authors: []nostr.PubKey{member}}, 100_000) {
+		if evt.Tags.Has("nip63") {
+			byTier[RequiredTier(evt)]++
+		}
+	}
+	paywalledCounts.Store(member, cachedPaywalledCount{byTier, now})
+	return maps.Clone(byTier)
+}
+
+// ForgetPaywalledEvents drops the count of paywalled events of a member, for when they publish or delete one.
+func ForgetPaywalledEvents(member nostr.PubKey) {
+	paywalledCounts.Delete(member)
+}
+
+func (mo MemberOverview) TotalPaywalledEvents() int {
+	total := 0
+	for _, count := range mo.PaywalledEvents {
+		total += count
+	}
+	return total
+}
+
+// Overview collects the paywall state of a member from the computed readers map.
+func Overview(member nostr.PubKey) MemberOverview {
+	overview := MemberOverview{
+		Member:          member,
+		PaywalledEvents: countPaywalledEvents(member),
+		Readers:         make([]Reader, 0),
+		References:      make([]ReferencedList, 0),
+		Offers:          GetOffers(member),
+	}
+	if overview.Offers == nil {
+		overview.Offers = make([]Offer, 0)
+	}
+
+	now := nostr.Now()
+	readers, _ := userPaywallMap.Load(member)
+	for acc, m := range readers {
+		reader := Reader{
+			PubKey:  acc.reader,
+			Tier:    acc.tier,
+			Source:  m.source,
+			Event:   m.id,
+			Granted: m.when,
+			Expires: m.expires,
+			Expired: m.expires != 0 && m.expires <= now,
+			Sats:    m.sats,
+			Comment: m.comment,
+		}
+		overview.Readers = append(overview.Readers, reader)
+	}
+	slices.SortFunc(overview.Readers, func(a, b Reader) int {
+		if c := cmp.Compare(b.Granted, a.Granted); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.PubKey.Hex(), b.PubKey.Hex())
+	})
+
+	// every accepted payment counts, not only the ones behind the current grants, since there is
+	// one grant per tier, but zaps that didn't buy any of the offers are just zaps
+	for payment := range global.IL.Inbox.QueryEvents(nostr.Filter{
+		Kinds: []nostr.Kind{9735, 9321},
+		Tags:  nostr.TagMap{"p": []string{member.Hex()}},
+		Since: now - revenuePeriod*86400,
+	}, 5000) {
+		if _, _, _, ok := grantFromPayment(payment, overview.Offers); !ok {
+			continue
+		}
+		if _, sats, _, ok := paymentDetails(payment); ok {
+			overview.Revenue += sats
+		}
+	}
+
+	mu.Lock()
+	references := slices.Clone(References)
+	mu.Unlock()
+	for _, ref := range references {
+		if ref.Member != member {
+			continue
+		}
+		list := ReferencedList{Address: fmt.Sprintf("%d:%s:%s", ref.Kind, ref.PublicKey.Hex(), ref.Identifier)}
+		filter := nostr.Filter{Authors: []nostr.PubKey{ref.PublicKey}, Kinds: []nostr.Kind{ref.Kind}}
+		if ref.Kind.IsAddressable() {
+			filter.Tags = nostr.TagMap{"d": []string{ref.Identifier}}
+		}
+		for evt := range global.IL.Main.QueryEvents(filter, 1) {
+			list.Found = true
+			for range evt.Tags.FindAll("p") {
+				list.Readers++
+			}
+		}
+		overview.References = append(overview.References, list)
+	}
+
+	return overview
+}
+
+// overviewsFor returns the paywalls a user can look at: all of them for root, only their own for members.
+func overviewsFor(loggedUser nostr.PubKey) []MemberOverview {
+	if pyramid.IsRoot(loggedUser) {
+		overviews := make([]MemberOverview, 0, pyramid.Members.Size())
+		now := nostr.Now()
+		for member := range pyramid.Members.Range {
+			cached, ok := overviewCache.Load(member)
+			if !ok || cached.at+overviewCacheDuration < now {
+				cached = cachedOverview{Overview(member), now}
+				overviewCache.Store(member, cached)
+			}
+			overview := cached.overview
+			if overview.TotalPaywalledEvents() > 0 || len(overview.Readers) > 0 || len(overview.Offers) > 0 {
+				overviews = append(overviews, overview)
+			}
+		}
+		slices.SortFunc(overviews, func(a, b MemberOverview) int {
+			return cmp.Compare(len(b.Readers), len(a.Readers))
+		})
+		return overviews
+	}
+	return []MemberOverview{Overview(loggedUser)}
+}
+
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsMember(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	dashboardPage(loggedUser, overviewsFor(loggedUser)).Render(r.Context(), w)
+}
+
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsMember(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="paywall-%s.json"`, nostr.Now().Time().Format("2006-01-02")))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(overviewsFor(loggedUser))
+}
@@ -3,6 +3,7 @@ package paywall
 import (
 	"context"
 	"net/http"
+	"strconv"
 
 	"fiatjaf.com/nostr/khatru"
 
@@ -41,6 +42,9 @@ func setupEnabled() {
 
 	Handler.mux = http.NewServeMux()
 	Handler.mux.HandleFunc("POST /paywall/disable", disableHandler)
+	Handler.mux.HandleFunc("POST /paywall/teaser", teaserHandler)
+	Handler.mux.HandleFunc("GET /paywall/dashboard", dashboardHandler)
+	Handler.mux.HandleFunc("GET /paywall/export", exportHandler)
 	Handler.mux.HandleFunc("/paywall/", pageHandler)
 }
 
@@ -87,6 +91,31 @@ func disableHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/paywall/", 302)
 }
 
+func teaserHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+
+	if !pyramid.IsRoot(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	length, err := strconv.Atoi(r.PostFormValue("teaser_length"))
+	if err != nil || length < 0 {
+		http.Error(w, "invalid teaser length", 400)
+		return
+	}
+	global.Settings.Paywall.TeaserLength = length
+
+	if err := global.SaveUserSettings(); err != nil {
+		http.Error(w, "failed to save settings: "+err.Error(), 500)
+		return
+	}
+
+	// teasers signed with the previous length are no longer valid
+	teasers.Clear()
+	http.Redirect(w, r, "/paywall/", 302)
+}
+
 type MuxHandler struct {
 	mux *http.ServeMux
 }
@@ -0,0 +1,255 @@
+package paywall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip57"
+	"github.com/puzpuzpuz/xsync/v3"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+// Offer is what a member charges for access to one of their tiers, taken from
+// ["price", "<sats>", "<days>", "<tier>"] tags in their kind:1163 events.
+type Offer struct {
+	Tier string `json:"tier"`
+	Sats uint64 `json:"sats"`
+	Days int    `json:"days"`
+}
+
+var memberOffers = xsync.NewMapOf[nostr.PubKey, []Offer]()
+
+// payments can't be dated further than this in the future, since access starts from their date
+const futureSlack = 10 * 60
+
+var (
+	// the first nutzap each cashu proof was seen in, so the same proofs can't be sent again to renew access
+	usedProofs     = xsync.NewMapOf[string, nostr.ID]()
+	usedProofsOnce sync.Once
+)
+
+type nutzapProof struct {
+	Amount uint64 `json:"amount"`
+	Secret string `json:"secret"`
+}
+
+func nutzapProofs(payment nostr.Event) []nutzapProof {
+	proofs := make([]nutzapProof, 0, 4)
+	for proofTag := range payment.Tags.FindAll("proof") {
+		var proof nutzapProof
+		if err := json.Unmarshal([]byte(proofTag[1]), &proof); err == nil {
+			proofs = append(proofs, proof)
+		}
+	}
+	return proofs
+}
+
+func loadUsedProofs() {
+	usedProofsOnce.Do(func() {
+		if global.IL.Inbox == nil {
+			return
+		}
+		// newest come first, so the oldest nutzap with each proof is the one that stays
+		for payment := range global.IL.Inbox.QueryEvents(nostr.Filter{Kinds: []nostr.Kind{9321}}, 1_000_000) {
+			for _, proof := range nutzapProofs(payment) {
+				usedProofs.Store(proof.Secret, payment.ID)
+			}
+		}
+	})
+}
+
+// ProofsAlreadyUsed tells if a nutzap carries proofs that were already sent in another one.
+func ProofsAlreadyUsed(payment nostr.Event) bool {
+	loadUsedProofs()
+	for _, proof := range nutzapProofs(payment) {
+		if first, ok := usedProofs.Load(proof.Secret); ok && first != payment.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// PaymentTooFarAhead tells if a payment is dated in the future, which would make the access it buys last longer.
+func PaymentTooFarAhead(payment nostr.Event) bool {
+	return payment.CreatedAt > nostr.Now()+futureSlack
+}
+
+// GetOffers returns the prices a member is currently asking, the most expensive first.
+func GetOffers(member nostr.PubKey) []Offer {
+	offers, _ := memberOffers.Load(member)
+	return offers
+}
+
+func HasOffers(member nostr.PubKey) bool {
+	return len(GetOffers(member)) > 0
+}
+
+func parseOffer(tag nostr.Tag) (Offer, bool) {
+	if len(tag) < 3 || tag[0] != "price" {
+		return Offer{}, false
+	}
+	sats, err := strconv.ParseUint(tag[1], 10, 64)
+	if err != nil || sats == 0 {
+		return Offer{}, false
+	}
+	days, err := strconv.Atoi(tag[2])
+	if err != nil || days <= 0 {
+		return Offer{}, false
+	}
+	offer := Offer{Sats: sats, Days: days}
+	if len(tag) >= 4 {
+		offer.Tier = tag[3]
+	}
+	return offer, true
+}
+
+// paymentDetails extracts who paid, how much (in sats) and the tier they asked for (if any) from
+// a zap receipt or a nutzap. these are validated by the inbox before being stored, so here we just read them.
+func paymentDetails(payment nostr.Event) (payer nostr.PubKey, sats uint64, tier string, ok bool) {
+	switch payment.Kind {
+	case 9735:
+		desc := payment.Tags.Find("description")
+		if desc == nil {
+			return payer, 0, "", false
+		}
+		var zapRequest nostr.Event
+		if err := json.Unmarshal([]byte(desc[1]), &zapRequest); err != nil || zapRequest.Kind != 9734 {
+			return payer, 0, "", false
+		}
+		// the payer is whoever signed the zap request, and it must be for the one that was paid
+		if !zapRequest.VerifySignature() {
+			return payer, 0, "", false
+		}
+		if p, rp := payment.Tags.Find("p"), zapRequest.Tags.Find("p"); p == nil || rp == nil || p[1] != rp[1] {
+			return payer, 0, "", false
+		}
+		payer = zapRequest.PubKey
+		if t := zapRequest.Tags.Find("tier"); t != nil {
+			tier = t[1]
+		}
+		sats = nip57.GetAmountFromZap(payment) / 1000
+	case 9321:
+		if unit := payment.Tags.Find("unit"); unit != nil && unit[1] != "sat" {
+			return payer, 0, "", false
+		}
+		payer = payment.PubKey
+		if t := payment.Tags.Find("tier"); t != nil {
+			tier = t[1]
+		}
+		if ProofsAlreadyUsed(payment) {
+			return payer, 0, "", false
+		}
+		for _, proof := range nutzapProofs(payment) {
+			sats += proof.Amount
+		}
+	default:
+		return payer, 0, "", false
+	}
+
+	return payer, sats, tier, sats > 0
+}
+
+// grantFromPayment picks the offer a payment is good for: the one for the requested tier if the payer
+// asked for one, otherwise the most expensive one the amount covers. access starts when the payment was made,
+// which the inbox makes sure is about when it was stored, and never later than now.
+func grantFromPayment(payment nostr.Event, offers []Offer) (nostr.PubKey, string, nostr.Timestamp, bool) {
+	if PaymentTooFarAhead(payment) {
+		return nostr.ZeroPK, "", 0, false
+	}
+	payer, sats, tier, ok := paymentDetails(payment)
+	if !ok {
+		return payer, "", 0, false
+	}
+
+	var best *Offer
+	for i, offer := range offers {
+		if offer.Sats > sats || (tier != "" && offer.Tier != tier) {
+			continue
+		}
+		if best == nil || offer.Sats > best.Sats || (offer.Sats == best.Sats && offer.Days > best.Days) {
+			best = &offers[i]
+		}
+	}
+	if best == nil {
+		return payer, "", 0, false
+	}
+
+	start := min(payment.CreatedAt, nostr.Now())
+	return payer, best.Tier, start + nostr.Timestamp(best.Days*86400), true
+}
+
+// collectPaymentGrants adds the readers that paid for access to the member's readers map.
+func collectPaymentGrants(member nostr.PubKey, offers []Offer, readers map[access]meta) {
+	longest := 0
+	for _, offer := range offers {
+		longest = max(longest, offer.Days)
+	}
+
+	now := nostr.Now()
+	for payment := range global.IL.Inbox.QueryEvents(nostr.Filter{
+		Kinds: []nostr.Kind{9735, 9321},
+		Tags:  nostr.TagMap{"p": []string{member.Hex()}},
+		Since: now - nostr.Timestamp(longest*86400),
+	}, 5000) {
+		payer, tier, expires, ok := grantFromPayment(payment, offers)
+		if !ok || expires <= now {
+			continue
+		}
+		_, sats, _, _ := paymentDetails(payment)
+		addGrant(readers, access{payer, tier}, meta{
+			source:  "payment",
+			id:      payment.ID,
+			when:    payment.CreatedAt,
+			comment: fmt.Sprintf("%d sats", sats),
+			expires: expires,
+			sats:    sats,
+		})
+	}
+}
+
+// ProcessPayment is called when a zap receipt or a nutzap is stored in the inbox, so the payer
+// gets access to the paywall of the member that was paid right away.
+func ProcessPayment(ctx context.Context, payment nostr.Event) {
+	if !global.Settings.Paywall.Enabled {
+		return
+	}
+	p := payment.Tags.Find("p")
+	if p == nil {
+		return
+	}
+	receiver, err := nostr.PubKeyFromHex(p[1])
+	if err != nil || !pyramid.IsMember(receiver) || !HasOffers(receiver) {
+		return
+	}
+
+	if payment.Kind == 9321 {
+		loadUsedProofs()
+		for _, proof := range nutzapProofs(payment) {
+			usedProofs.LoadOrStore(proof.Secret, payment.ID)
+		}
+	}
+
+	if payer, tier, _, ok := grantFromPayment(payment, GetOffers(receiver)); ok {
+		log.Info().Str("member", receiver.Hex()).Str("payer", payer.Hex()).Str("tier", tier).Msg("paywall access bought")
+		RecomputeMemberPaywall(ctx, receiver)
+	}
+}
+
+func sortOffers(offers []Offer) {
+	slices.SortFunc(offers, func(a, b Offer) int {
+		if a.Sats != b.Sats {
+			if a.Sats > b.Sats {
+				return -1
+			}
+			return 1
+		}
+		return b.Days - a.Days
+	})
+}
@@ -0,0 +1,119 @@
+package paywall
+
+import (
+	"encoding/json"
+	"testing"
+
+	"fiatjaf.com/nostr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrantFromPayment(t *testing.T) {
+	member := nostr.Generate()
+	payer := nostr.Generate()
+	provider := nostr.Generate() // stand-in for the member's zap provider
+
+	offers := []Offer{{Tier: "gold", Sats: 5000, Days: 30}, {Tier: "", Sats: 1000, Days: 7}}
+	sortOffers(offers)
+
+	zap := func(msats string, tags ...nostr.Tag) nostr.Event {
+		request := nostr.Event{
+			Kind:      9734,
+			CreatedAt: nostr.Now(),
+			Tags:      append(nostr.Tags{{"p", member.Public().Hex()}, {"amount", msats}}, tags...),
+		}
+		request.Sign(payer)
+		receipt := nostr.Event{
+			Kind:      9735,
+			CreatedAt: nostr.Now(),
+			Tags: nostr.Tags{
+				{"p", member.Public().Hex()},
+				{"description", request.String()},
+			},
+		}
+		receipt.Sign(provider)
+		return receipt
+	}
+
+	// the best offer the amount covers
+	receipt := zap("6000000")
+	reader, tier, expires, ok := grantFromPayment(receipt, offers)
+	require.True(t, ok)
+	require.Equal(t, payer.Public(), reader)
+	require.Equal(t, "gold", tier)
+	require.Equal(t, receipt.CreatedAt+30*86400, expires)
+
+	reader, tier, _, ok = grantFromPayment(zap("2000000"), offers)
+	require.True(t, ok)
+	require.Equal(t, payer.Public(), reader)
+	require.Equal(t, "", tier)
+
+	// too little
+	_, _, _, ok = grantFromPayment(zap("500000"), offers)
+	require.False(t, ok)
+
+	// asking for a tier the amount doesn't cover
+	_, _, _, ok = grantFromPayment(zap("2000000", nostr.Tag{"tier", "gold"}), offers)
+	require.False(t, ok)
+
+	// nutzaps are paid by their author, the amount is in the proofs
+	nutzap := nostr.Event{
+		Kind:      9321,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"p", member.Public().Hex()},
+			{"unit", "sat"},
+			{"proof", `{"amount":4096,"id":"x","secret":"y","C":"z"}`},
+			{"proof", `{"amount":1024,"id":"x","secret":"w","C":"z"}`},
+			{"tier", "gold"},
+		},
+	}
+	nutzap.Sign(payer)
+	reader, tier, _, ok = grantFromPayment(nutzap, offers)
+	require.True(t, ok)
+	require.Equal(t, payer.Public(), reader)
+	require.Equal(t, "gold", tier)
+
+	// the same proofs can't pay twice
+	replay := nutzap
+	replay.CreatedAt++
+	replay.Sign(payer)
+	usedProofs.Store("y", nutzap.ID)
+	_, _, _, ok = grantFromPayment(replay, offers)
+	require.False(t, ok)
+	_, _, _, ok = grantFromPayment(nutzap, offers)
+	require.True(t, ok)
+
+	// payments dated in the future don't buy extra time
+	future := nutzap
+	future.CreatedAt = nostr.Now() + 86400
+	future.Sign(payer)
+	_, _, _, ok = grantFromPayment(future, offers)
+	require.False(t, ok)
+
+	// zap requests must be signed and for the member that got the receipt
+	forged := zap("6000000")
+	var request nostr.Event
+	require.NoError(t, json.Unmarshal([]byte(forged.Tags.Find("description")[1]), &request))
+	request.Tags = append(request.Tags, nostr.Tag{"tier", "gold"})
+	forged.Tags = nostr.Tags{{"p", member.Public().Hex()}, {"description", request.String()}}
+	forged.Sign(provider)
+	_, _, _, ok = grantFromPayment(forged, offers)
+	require.False(t, ok)
+
+	other := nostr.Generate()
+	misdirected := zap("6000000")
+	misdirected.Tags[0] = nostr.Tag{"p", other.Public().Hex()}
+	misdirected.Sign(provider)
+	_, _, _, ok = grantFromPayment(misdirected, offers)
+	require.False(t, ok)
+
+	// grants that last longer win
+	readers := make(map[access]meta)
+	acc := access{payer.Public(), "gold"}
+	addGrant(readers, acc, meta{source: "payment", expires: 100})
+	addGrant(readers, acc, meta{source: "payment", expires: 50})
+	require.Equal(t, nostr.Timestamp(100), readers[acc].expires)
+	addGrant(readers, acc, meta{source: "direct"})
+	require.Equal(t, nostr.Timestamp(0), readers[acc].expires)
+}
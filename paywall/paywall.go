@@ -4,6 +4,7 @@ import (
 	"context"
 	"iter"
 	"slices"
+	"strconv"
 	"sync"
 
 	"fiatjaf.com/nostr"
@@ -16,9 +17,17 @@ type meta struct {
 	id      nostr.ID
 	when    nostr.Timestamp
 	comment string
+	expires nostr.Timestamp // zero means the access never expires
+	sats    uint64          // amount paid, for grants that come from payments
 }
 
-var userPaywallMap = xsync.NewMapOf[nostr.PubKey, map[nostr.PubKey]meta]()
+// access is a reader's grant to one of the tiers of a member, the empty tier being the default one.
+type access struct {
+	reader nostr.PubKey
+	tier   string
+}
+
+var userPaywallMap = xsync.NewMapOf[nostr.PubKey, map[access]meta]()
 
 // PaywallReference associates an addressable or replaceable event from a kind:1163 event from Author with a
 // a filter such that when such replaceable event is updated we know to react.
@@ -53,41 +62,100 @@ func ReferencedBy(event nostr.Event) iter.Seq[nostr.PubKey] {
 	}
 }
 
-// CanRead checks if a reader can access an author's paywalled content
-func CanRead(author, reader nostr.PubKey) bool {
+// RequiredTier is the tier a paywalled event is restricted to, given as ["nip63", "<tier>"].
+// events that don't specify a tier are in the default tier.
+func RequiredTier(event nostr.Event) string {
+	if tag := event.Tags.Find("nip63"); tag != nil {
+		return tag[1]
+	}
+	return ""
+}
+
+// CanRead checks if a reader currently has access to the given tier of an author's paywalled content
+func CanRead(author, reader nostr.PubKey, tier string) bool {
 	if author == reader {
 		return true
 	}
 	if userMap, ok := userPaywallMap.Load(author); ok {
-		_, exists := userMap[reader]
-		return exists
+		m, exists := userMap[access{reader, tier}]
+		return exists && (m.expires == 0 || m.expires > nostr.Now())
 	}
 	return false
 }
 
+// CanReadEvent checks if a reader can access a paywalled event, in whatever tier it is.
+func CanReadEvent(event nostr.Event, reader nostr.PubKey) bool {
+	return CanRead(event.PubKey, reader, RequiredTier(event))
+}
+
+// grantExpiration takes the expiration of a grant from the 4th item of the "p" tag ("p", pubkey, relay, expiration)
+// or from the NIP-40 expiration of the kind:1163 event itself, whatever comes first.
+func grantExpiration(evt nostr.Event, tag nostr.Tag) nostr.Timestamp {
+	var expires nostr.Timestamp
+	if exp := evt.Tags.Find("expiration"); exp != nil {
+		if ts, err := strconv.ParseInt(exp[1], 10, 64); err == nil {
+			expires = nostr.Timestamp(ts)
+		}
+	}
+	if len(tag) >= 4 {
+		if ts, err := strconv.ParseInt(tag[3], 10, 64); err == nil && (expires == 0 || nostr.Timestamp(ts) < expires) {
+			expires = nostr.Timestamp(ts)
+		}
+	}
+	return expires
+}
+
+// addGrant keeps the grant that lasts longer when the same reader is granted the same tier more than once.
+func addGrant(readers map[access]meta, acc access, m meta) {
+	if existing, ok := readers[acc]; ok && (existing.expires == 0 || (m.expires != 0 && m.expires <= existing.expires)) {
+		return
+	}
+	readers[acc] = m
+}
+
 var mu sync.Mutex
 
 // RecomputeMemberPaywall rebuilds paywall access map for a given user
 // by reading all their kind:1163 events and collecting "p" tags
 // It also reads "a" tags to build filters for referenced events
+// Each kind:1163 event grants the tier named in its "tier" tag, or the default tier
 func RecomputeMemberPaywall(ctx context.Context, member nostr.PubKey) {
 	mu.Lock()
 	defer mu.Unlock()
 
 	// build new map of who can read this user's content
-	newReaders := make(map[nostr.PubKey]meta)
+	newReaders := make(map[access]meta)
 
 	var newReferences []PaywallReference
+	var newOffers []Offer
 
 	for evt := range global.IL.Main.QueryEvents(nostr.Filter{
 		Authors: []nostr.PubKey{member},
 		Kinds:   []nostr.Kind{1163},
 	}, 1000) {
+		tier := ""
+		if tag := evt.Tags.Find("tier"); tag != nil {
+			tier = tag[1]
+		}
+
+		// prices for buying access, the latest event wins for each tier
+		for _, tag := range evt.Tags {
+			if offer, ok := parseOffer(tag); ok && !slices.ContainsFunc(newOffers, func(o Offer) bool { return o.Tier == offer.Tier }) {
+				newOffers = append(newOffers, offer)
+			}
+		}
+
 		// collect all "p" tags (pubkeys) from this event
 		for _, tag := range evt.Tags {
 			if len(tag) >= 2 && tag[0] == "p" {
 				if pk, err := nostr.PubKeyFromHex(tag[1]); err == nil {
-					newReaders[pk] = meta{source: "direct", id: evt.ID, when: evt.CreatedAt, comment: evt.Content}
+					addGrant(newReaders, access{pk, tier}, meta{
+						source:  "direct",
+						id:      evt.ID,
+						when:    evt.CreatedAt,
+						comment: evt.Content,
+						expires: grantExpiration(evt, tag),
+					})
 				}
 			}
 		}
@@ -112,7 +180,13 @@ func RecomputeMemberPaywall(ctx context.Context, member nostr.PubKey) {
 						for _, tag := range refEvt.Tags {
 							if len(tag) >= 2 && tag[0] == "p" {
 								if pk, err := nostr.PubKeyFromHex(tag[1]); err == nil {
-									newReaders[pk] = meta{source: "reference", id: refEvt.ID, when: evt.CreatedAt, comment: evt.Content}
+									addGrant(newReaders, access{pk, tier}, meta{
+										source:  "reference",
+										id:      refEvt.ID,
+										when:    evt.CreatedAt,
+										comment: evt.Content,
+										expires: grantExpiration(evt, nil),
+									})
 								}
 							}
 						}
@@ -122,6 +196,12 @@ func RecomputeMemberPaywall(ctx context.Context, member nostr.PubKey) {
 		}
 	}
 
+	// readers that paid for access
+	sortOffers(newOffers)
+	if len(newOffers) > 0 {
+		collectPaymentGrants(member, newOffers, newReaders)
+	}
+
 	// now actually update the global references list: drop everything this
 	// member had and add back what they currently reference
 	References = slices.DeleteFunc(References, func(r PaywallReference) bool {
@@ -131,4 +211,6 @@ func RecomputeMemberPaywall(ctx context.Context, member nostr.PubKey) {
 
 	// update readers map
 	userPaywallMap.Store(member, newReaders)
+	memberOffers.Store(member, newOffers)
+	forgetTeasersBy(member)
 }
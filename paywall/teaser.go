@@ -0,0 +1,116 @@
+package paywall
+
+import (
+	"fmt"
+	"slices"
+	"unicode/utf8"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip19"
+	"github.com/puzpuzpuz/xsync/v3"
+
+	"github.com/fiatjaf/pyramid/global"
+)
+
+// teasers are signed once and reused, keyed by the id of the paywalled event
+var teasers = xsync.NewMapOf[nostr.ID, nostr.Event]()
+
+// when there are more than this many teasers the old ones are dropped and signed again when needed
+const maxCachedTeasers = 5000
+
+// tags that are copied from the paywalled event to its teaser
+var teaserKeptTags = []string{"t", "title", "summary", "image", "published_at", "content-warning"}
+
+// Teaser returns a relay-signed preview of a paywalled event to be served to readers that can't read it.
+// it has the same kind and timestamp, the "summary" or the beginning of the content, a pointer to the
+// original and a note on how to get access. the ["nip63-teaser", "<tier>"] tag identifies it.
+func Teaser(event nostr.Event) (nostr.Event, bool) {
+	length := global.Settings.Paywall.TeaserLength
+	if length <= 0 {
+		return nostr.Event{}, false
+	}
+
+	if teaser, ok := teasers.Load(event.ID); ok {
+		return teaser, true
+	}
+
+	// the summary is cut too, authors may have put more in there than root wants to give away
+	preview := event.Content
+	if summary := event.Tags.Find("summary"); summary != nil {
+		preview = summary[1]
+	}
+	preview = truncate(preview, length)
+
+	relayURL := global.Settings.WSScheme() + global.Settings.Domain
+	teaser := nostr.Event{
+		Kind:      event.Kind,
+		CreatedAt: event.CreatedAt,
+		Content: fmt.Sprintf("%s\n\nthis is a preview of paywalled content by nostr:%s, subscribe at %s to read more.",
+			preview, nip19.EncodeNpub(event.PubKey), global.Settings.HTTPScheme()+global.Settings.Domain+"/paywall/"),
+		Tags: nostr.Tags{
+			{"-"},
+			{"nip63-teaser", RequiredTier(event)},
+			{"e", event.ID.Hex(), relayURL},
+			{"p", event.PubKey.Hex(), relayURL},
+			{"alt", "preview of paywalled content"},
+		},
+	}
+	if event.Kind.IsAddressable() {
+		// so teasers of different authors never replace each other
+		teaser.Tags = append(teaser.Tags, nostr.Tag{"d", event.PubKey.Hex() + ":" + event.Tags.GetD()})
+		teaser.Tags = append(teaser.Tags, nostr.Tag{"a", fmt.Sprintf("%d:%s:%s", event.Kind, event.PubKey.Hex(), event.Tags.GetD()), relayURL})
+	}
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && slices.Contains(teaserKeptTags, tag[0]) {
+			if tag[0] == "summary" {
+				tag = nostr.Tag{"summary", preview}
+			}
+			teaser.Tags = append(teaser.Tags, tag)
+		}
+	}
+
+	if err := teaser.Sign(global.Settings.RelayInternalSecretKey); err != nil {
+		return nostr.Event{}, false
+	}
+	if teasers.Size() >= maxCachedTeasers {
+		teasers.Clear()
+	}
+	teasers.Store(event.ID, teaser)
+	return teaser, true
+}
+
+// ForgetTeaser drops the cached teaser of a paywalled event that was deleted or replaced.
+func ForgetTeaser(id nostr.ID) {
+	teasers.Delete(id)
+}
+
+// forgetTeasersBy drops the cached teasers of a member, for when their paywall changes.
+func forgetTeasersBy(member nostr.PubKey) {
+	teasers.Range(func(id nostr.ID, teaser nostr.Event) bool {
+		if p := teaser.Tags.Find("p"); p != nil && p[1] == member.Hex() {
+			teasers.Delete(id)
+		}
+		return true
+	})
+}
+
+func truncate(text string, length int) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	return string([]rune(text)[0:length]) + "…"
+}
+
+// TeaserIsRedundant tells if a reader can already read the original of a teaser, so they don't need it.
+func TeaserIsRedundant(teaser nostr.Event, reader nostr.PubKey) bool {
+	tag := teaser.Tags.Find("nip63-teaser")
+	p := teaser.Tags.Find("p")
+	if tag == nil || p == nil || teaser.PubKey != global.Settings.RelayInternalSecretKey.Public() {
+		return false
+	}
+	author, err := nostr.PubKeyFromHex(p[1])
+	if err != nil {
+		return false
+	}
+	return CanRead(author, reader, tag[1])
+}
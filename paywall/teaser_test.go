@@ -0,0 +1,64 @@
+package paywall
+
+import (
+	"strings"
+	"testing"
+
+	"fiatjaf.com/nostr"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fiatjaf/pyramid/global"
+)
+
+func TestTeaser(t *testing.T) {
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
+	global.Settings.Domain = "example.com"
+	global.Settings.Paywall.TeaserLength = 10
+
+	author := nostr.Generate()
+	reader := nostr.Generate()
+
+	evt := nostr.Event{
+		Kind:      30023,
+		CreatedAt: nostr.Now(),
+		Content:   "the secret ending of this story is that everybody lives",
+		Tags:      nostr.Tags{{"-"}, {"nip63", "gold"}, {"d", "story"}, {"title", "a story"}},
+	}
+	evt.Sign(author)
+
+	teaser, ok := Teaser(evt)
+	require.True(t, ok)
+	require.True(t, teaser.VerifySignature())
+	require.Equal(t, global.Settings.RelayInternalSecretKey.Public(), teaser.PubKey)
+	require.Equal(t, evt.Kind, teaser.Kind)
+	require.True(t, strings.HasPrefix(teaser.Content, "the secret…"))
+	require.NotContains(t, teaser.Content, "everybody lives")
+	require.Equal(t, "gold", teaser.Tags.Find("nip63-teaser")[1])
+	require.Equal(t, "a story", teaser.Tags.Find("title")[1])
+	require.Nil(t, teaser.Tags.Find("nip63"))
+
+	// readers with access don't need the teaser
+	require.False(t, TeaserIsRedundant(teaser, reader.Public()))
+	userPaywallMap.Store(author.Public(), map[access]meta{{reader.Public(), "gold"}: {source: "direct"}})
+	defer userPaywallMap.Delete(author.Public())
+	require.True(t, TeaserIsRedundant(teaser, reader.Public()))
+
+	// long summaries are cut just like the content
+	withSummary := nostr.Event{
+		Kind:      30023,
+		CreatedAt: nostr.Now(),
+		Content:   "the secret ending of this story is that everybody lives",
+		Tags:      nostr.Tags{{"-"}, {"nip63", "gold"}, {"d", "other"}, {"summary", "in the end everybody lives"}},
+	}
+	withSummary.Sign(author)
+	teaser, ok = Teaser(withSummary)
+	require.True(t, ok)
+	require.True(t, strings.HasPrefix(teaser.Content, "in the end…"))
+	require.Equal(t, "in the end…", teaser.Tags.Find("summary")[1])
+	require.NotContains(t, teaser.String(), "everybody lives")
+
+	ForgetTeaser(evt.ID)
+	global.Settings.Paywall.TeaserLength = 0
+	_, ok = Teaser(evt)
+	require.False(t, ok)
+}
@@ -0,0 +1,411 @@
+package personal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/khatru"
+	"fiatjaf.com/nostr/nip44"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+// events of members that set up encryption are stored sealed inside envelopes: events of their own kind,
+// signed by the relay, with the original event encrypted to the member's storage key as their content.
+// the storage key is generated by the member's browser, which keeps its secret wrapped with NIP-44 to the
+// member in a kind 30078 event, so the relay only ever knows its public key and can't open envelopes.
+// they are opened by the member's browser, when exporting, or by any client that knows the storage key.
+//
+// envelopes keep some things readable so they can be found and replaced: the owner ("p"), the kind ("k"),
+// the id ("e") and the timestamp of the original event, plus its "d" tag hashed with the storage key.
+// deletions pointing at the original ids or addresses find the envelopes through these. the hashed "d"
+// doesn't hide identifiers that can be guessed, since the storage key it is hashed with is public.
+const (
+	keyWrapIdentifier = "pyramid/personal-storage"
+	envelopeKind      = nostr.Kind(8059)
+	ephemeralTag      = "encrypted" // has the public key of the ephemeral key each envelope is sealed with
+)
+
+func isKeyWrap(evt nostr.Event) bool {
+	return evt.Kind == 30078 && evt.Tags.GetD() == keyWrapIdentifier
+}
+
+func isEnvelope(evt nostr.Event) bool {
+	return evt.Kind == envelopeKind && evt.PubKey == global.Settings.RelayInternalSecretKey.Public()
+}
+
+// ownerOf is the member a stored event belongs to, which for envelopes is in the "p" tag.
+func ownerOf(evt nostr.Event) nostr.PubKey {
+	if isEnvelope(evt) {
+		if p := evt.Tags.Find("p"); p != nil {
+			if pk, err := nostr.PubKeyFromHex(p[1]); err == nil {
+				return pk
+			}
+		}
+		return nostr.ZeroPK
+	}
+	return evt.PubKey
+}
+
+// kindOf is the kind of a stored event, which for envelopes is the kind of what is inside.
+func kindOf(evt nostr.Event) nostr.Kind {
+	if isEnvelope(evt) {
+		if k := evt.Tags.Find("k"); k != nil {
+			if kind, err := strconv.Atoi(k[1]); err == nil {
+				return nostr.Kind(kind)
+			}
+		}
+	}
+	return evt.Kind
+}
+
+// keyWrap is the event in which a member keeps the secret of their storage key encrypted to themselves.
+func keyWrap(member nostr.PubKey) *nostr.Event {
+	for evt := range global.IL.Personal.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{30078},
+		Authors: []nostr.PubKey{member},
+		Tags:    nostr.TagMap{"d": []string{keyWrapIdentifier}},
+	}, 1) {
+		return &evt
+	}
+	return nil
+}
+
+// storageKey is the public key events of a member are sealed to, if they set up encryption.
+func storageKey(member nostr.PubKey) (nostr.PubKey, bool) {
+	wrap := keyWrap(member)
+	if wrap == nil {
+		return nostr.ZeroPK, false
+	}
+	tag := wrap.Tags.Find("storage")
+	if tag == nil {
+		return nostr.ZeroPK, false
+	}
+	pk, err := nostr.PubKeyFromHex(tag[1])
+	return pk, err == nil
+}
+
+// blindD hides the "d" tag of an addressable event while still letting it be replaced.
+func blindD(storage nostr.PubKey, d string) string {
+	h := sha256.New()
+	h.Write(storage[:])
+	h.Write([]byte(d))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// seal makes the envelope an event is stored in. the content is the event encrypted with XChaCha20-Poly1305
+// (events can be larger than what NIP-44 takes) keyed with the NIP-44 conversation key between an ephemeral
+// key and the storage key, with the id of the event as additional data.
+func seal(evt nostr.Event, storage nostr.PubKey) (nostr.Event, error) {
+	ephemeral := nostr.Generate()
+	key, err := nip44.GenerateConversationKey(storage, ephemeral)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	plaintext, err := json.Marshal(evt)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nostr.Event{}, err
+	}
+
+	envelope := nostr.Event{
+		Kind:      envelopeKind,
+		CreatedAt: evt.CreatedAt,
+		Tags: nostr.Tags{
+			{"p", evt.PubKey.Hex()},
+			{"k", strconv.Itoa(int(evt.Kind))},
+			{"e", evt.ID.Hex()},
+			{ephemeralTag, ephemeral.Public().Hex()},
+		},
+		Content: base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, evt.ID[:])),
+	}
+	if evt.Kind.IsAddressable() {
+		envelope.Tags = append(envelope.Tags, nostr.Tag{"d", blindD(storage, evt.Tags.GetD())})
+	}
+	if err := envelope.Sign(global.Settings.RelayInternalSecretKey); err != nil {
+		return nostr.Event{}, err
+	}
+	return envelope, nil
+}
+
+// prepareForStorage seals an event if its author has set up encryption.
+func prepareForStorage(evt nostr.Event) (nostr.Event, error) {
+	if isKeyWrap(evt) {
+		return evt, nil
+	}
+	storage, ok := storageKey(evt.PubKey)
+	if !ok {
+		if global.Settings.Personal.Encrypted {
+			return evt, fmt.Errorf("encryption isn't set up")
+		}
+		return evt, nil
+	}
+	return seal(evt, storage)
+}
+
+// alreadySealed tells if there is an envelope for an event already, since envelopes of the same event
+// have different ids and the store can't tell they are the same.
+func alreadySealed(evt nostr.Event) bool {
+	for range global.IL.Personal.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{envelopeKind},
+		Authors: []nostr.PubKey{global.Settings.RelayInternalSecretKey.Public()},
+		Tags:    nostr.TagMap{"p": []string{evt.PubKey.Hex()}, "e": []string{evt.ID.Hex()}},
+	}, 1) {
+		return true
+	}
+	return false
+}
+
+// replaceSealed stores the envelope of a replaceable event, removing the envelopes (or the events stored before
+// encryption was set up) of the versions it replaces. envelopes are regular events, so the store can't do this.
+func replaceSealed(envelope nostr.Event, original nostr.Event) ([]nostr.Event, error) {
+	db := global.IL.Personal
+
+	previousEnvelopes := nostr.Filter{
+		Kinds:   []nostr.Kind{envelopeKind},
+		Authors: []nostr.PubKey{global.Settings.RelayInternalSecretKey.Public()},
+		Tags:    nostr.TagMap{"p": []string{original.PubKey.Hex()}, "k": []string{strconv.Itoa(int(original.Kind))}},
+	}
+	previousPlain := nostr.Filter{Kinds: []nostr.Kind{original.Kind}, Authors: []nostr.PubKey{original.PubKey}}
+	if original.Kind.IsAddressable() {
+		previousEnvelopes.Tags["d"] = []string{envelope.Tags.GetD()}
+		previousPlain.Tags = nostr.TagMap{"d": []string{original.Tags.GetD()}}
+	}
+
+	replaced := make([]nostr.Event, 0, 1)
+	for _, filter := range []nostr.Filter{previousEnvelopes, previousPlain} {
+		for previous := range db.QueryEvents(filter, 10) {
+			// compare with what is inside the envelope, not with the envelope itself
+			compared := previous
+			if isEnvelope(previous) {
+				if e := previous.Tags.Find("e"); e != nil {
+					compared.ID, _ = nostr.IDFromHex(e[1])
+				}
+			}
+			if !nostr.IsOlder(compared, original) {
+				// we already have this or a newer version
+				return nil, nil
+			}
+			replaced = append(replaced, previous)
+		}
+	}
+
+	for _, previous := range replaced {
+		if err := db.DeleteEvent(previous.ID); err != nil {
+			return nil, err
+		}
+	}
+	return replaced, db.SaveEvent(envelope)
+}
+
+// envelopeFilter turns a filter into one that finds the envelopes of a member. lookups made by the relay
+// itself (as when deleting) point at the original events, while members ask for their envelopes as they are.
+func envelopeFilter(ctx context.Context, authed nostr.PubKey, filter nostr.Filter) (nostr.Filter, bool) {
+	relay := global.Settings.RelayInternalSecretKey.Public()
+
+	if khatru.IsInternalCall(ctx) {
+		if len(filter.Authors) > 0 && !slices.Contains(filter.Authors, authed) {
+			return filter, false
+		}
+		lookup := nostr.Filter{
+			Kinds:   []nostr.Kind{envelopeKind},
+			Authors: []nostr.PubKey{relay},
+			Tags:    nostr.TagMap{"p": []string{authed.Hex()}},
+			Since:   filter.Since,
+			Until:   filter.Until,
+		}
+		if len(filter.IDs) > 0 {
+			lookup.Tags["e"] = make([]string, len(filter.IDs))
+			for i, id := range filter.IDs {
+				lookup.Tags["e"][i] = id.Hex()
+			}
+		}
+		if len(filter.Kinds) > 0 {
+			lookup.Tags["k"] = make([]string, len(filter.Kinds))
+			for i, kind := range filter.Kinds {
+				lookup.Tags["k"][i] = strconv.Itoa(int(kind))
+			}
+		}
+		if ds, ok := filter.Tags["d"]; ok {
+			storage, hasKey := storageKey(authed)
+			if !hasKey {
+				return filter, false
+			}
+			lookup.Tags["d"] = make([]string, len(ds))
+			for i, d := range ds {
+				lookup.Tags["d"][i] = blindD(storage, d)
+			}
+		}
+		return lookup, true
+	}
+
+	// by id they are found among the plain events
+	if len(filter.IDs) > 0 || len(filter.Kinds) > 0 && !slices.Contains(filter.Kinds, envelopeKind) {
+		return filter, false
+	}
+	if len(filter.Authors) > 0 && !slices.Contains(filter.Authors, authed) && !slices.Contains(filter.Authors, relay) {
+		return filter, false
+	}
+	filter.Kinds = []nostr.Kind{envelopeKind}
+	filter.Authors = []nostr.PubKey{relay}
+	tags := make(nostr.TagMap, len(filter.Tags)+1)
+	for k, v := range filter.Tags {
+		tags[k] = v
+	}
+	tags["p"] = []string{authed.Hex()}
+	filter.Tags = tags
+	return filter, true
+}
+
+// queryEncrypted answers a query from a member whose events may be sealed: first the events stored as they
+// are (the key wrap and whatever was there before encryption was set up), then the envelopes.
+func queryEncrypted(ctx context.Context, authed nostr.PubKey, filter nostr.Filter) iter.Seq[nostr.Event] {
+	return func(yield func(nostr.Event) bool) {
+		db := global.IL.Personal
+		limit := global.Settings.Limits.MaxQueryLimit
+		if filter.Limit > 0 && filter.Limit < limit {
+			limit = filter.Limit
+		}
+
+		count := 0
+		plain := filter
+		askedForOthers := false
+		if len(plain.IDs) == 0 {
+			askedForOthers = len(plain.Authors) > 0 && !slices.Contains(plain.Authors, authed)
+			plain.Authors = []nostr.PubKey{authed}
+		}
+		if !askedForOthers {
+			for evt := range db.QueryEvents(plain, limit) {
+				if ownerOf(evt) != authed {
+					continue
+				}
+				if !yield(evt) {
+					return
+				}
+				count++
+				if count >= limit {
+					return
+				}
+			}
+		}
+
+		if envelopes, ok := envelopeFilter(ctx, authed, filter); ok {
+			for evt := range db.QueryEvents(envelopes, limit-count) {
+				if ownerOf(evt) != authed {
+					continue
+				}
+				if !yield(evt) {
+					return
+				}
+				count++
+				if count >= limit {
+					return
+				}
+			}
+		}
+	}
+}
+
+// sealExisting encrypts the events a member stored before they set up encryption.
+func sealExisting(member nostr.PubKey, storage nostr.PubKey) {
+	plain := make([]nostr.Event, 0, 100)
+	for evt := range global.IL.Personal.QueryEvents(nostr.Filter{Authors: []nostr.PubKey{member}}, 1_000_000) {
+		if !isKeyWrap(evt) {
+			plain = append(plain, evt)
+		}
+	}
+
+	for _, evt := range plain {
+		envelope, err := seal(evt, storage)
+		if err != nil {
+			continue
+		}
+		if err := global.IL.Personal.DeleteEvent(evt.ID); err != nil {
+			continue
+		}
+		if err := global.IL.Personal.SaveEvent(envelope); err != nil {
+			log.Error().Err(err).Str("id", evt.ID.Hex()).Msg("failed to store sealed personal event")
+		}
+	}
+	forgetUsage(member)
+	if len(plain) > 0 {
+		log.Info().Str("member", member.Hex()).Int("events", len(plain)).Msg("sealed existing personal events")
+	}
+}
+
+func storageKeyHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsMember(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Wrap *nostr.Event `json:"wrap"`
+	}{keyWrap(loggedUser)})
+}
+
+// setupStorageKeyHandler takes the key wrap of a storage key a member generated, after which their events are sealed.
+func setupStorageKeyHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsMember(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	var body struct {
+		Wrap nostr.Event `json:"wrap"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	if keyWrap(loggedUser) != nil {
+		http.Error(w, "you already have a storage key", 400)
+		return
+	}
+	if body.Wrap.PubKey != loggedUser || !isKeyWrap(body.Wrap) || !body.Wrap.VerifySignature() {
+		http.Error(w, "invalid key wrap event", 400)
+		return
+	}
+	tag := body.Wrap.Tags.Find("storage")
+	if tag == nil {
+		http.Error(w, "key wrap event is missing the storage key", 400)
+		return
+	}
+	storage, err := nostr.PubKeyFromHex(tag[1])
+	if err != nil {
+		http.Error(w, "invalid storage key", 400)
+		return
+	}
+
+	if _, err := global.IL.Personal.ReplaceEvent(body.Wrap); err != nil {
+		http.Error(w, "failed to save key wrap event: "+err.Error(), 500)
+		return
+	}
+	log.Info().Str("member", loggedUser.Hex()).Msg("personal storage encryption set up")
+
+	go sealExisting(loggedUser, storage)
+	w.WriteHeader(200)
+}
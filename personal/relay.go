@@ -8,6 +8,7 @@ import (
 	"slices"
 
 	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/eventstore"
 	"fiatjaf.com/nostr/khatru"
 	"fiatjaf.com/nostr/khatru/policies"
 	"fiatjaf.com/nostr/nip11"
@@ -66,18 +67,55 @@ func setupEnabled() {
 	Relay.QueryStored = query
 
 	Relay.StoreEvent = func(ctx context.Context, event nostr.Event) error {
-		return db.SaveEvent(event)
+		stored, err := prepareForStorage(event)
+		if err != nil {
+			return err
+		}
+		if isEnvelope(stored) && alreadySealed(event) {
+			return eventstore.ErrDupEvent
+		}
+		if err := db.SaveEvent(stored); err != nil {
+			return err
+		}
+		countStored(stored)
+		return nil
 	}
 
 	Relay.ReplaceEvent = func(ctx context.Context, event nostr.Event) error {
-		_, err := db.ReplaceEvent(event)
-		return err
+		stored, err := prepareForStorage(event)
+		if err != nil {
+			return err
+		}
+		for range db.QueryEvents(nostr.Filter{IDs: []nostr.ID{stored.ID}}, 1) {
+			// already stored
+			return nil
+		}
+
+		var replaced []nostr.Event
+		if isEnvelope(stored) {
+			replaced, err = replaceSealed(stored, event)
+		} else {
+			replaced, err = db.ReplaceEvent(stored)
+		}
+		if err != nil {
+			return err
+		}
+		countReplaced(stored, replaced)
+		return nil
 	}
 
 	Relay.DeleteEvent = func(ctx context.Context, id nostr.ID) error {
+		for evt := range db.QueryEvents(nostr.Filter{IDs: []nostr.ID{id}}, 1) {
+			forgetUsage(ownerOf(evt))
+		}
 		return db.DeleteEvent(id)
 	}
 
+	// deletions find envelopes, which are signed by the relay but belong to the member in their "p" tag
+	Relay.AllowDeleting = func(ctx context.Context, target nostr.Event, deletion nostr.Event) bool {
+		return ownerOf(target) == deletion.PubKey
+	}
+
 	pk := global.Settings.RelayInternalSecretKey.Public()
 	Relay.Info.Self = &pk
 	Relay.Info.PubKey = &pk
@@ -126,6 +164,20 @@ func setupEnabled() {
 				return true, "auth-required: you must prove you are the author"
 			}
 		},
+		func(ctx context.Context, evt nostr.Event) (bool, string) {
+			if isKeyWrap(evt) {
+				return true, "blocked: set up encryption of your personal storage at " + global.Settings.Personal.GetPageURL()
+			}
+			if evt.Kind == envelopeKind {
+				return true, "blocked: this kind is reserved for encrypted personal events"
+			}
+
+			if _, hasKey := storageKey(evt.PubKey); !hasKey && global.Settings.Personal.Encrypted {
+				return true, "restricted: set up encryption of your personal storage at " + global.Settings.Personal.GetPageURL()
+			}
+			return false, ""
+		},
+		exceedsQuota,
 	)
 
 	mux := http.NewServeMux()
@@ -134,6 +186,10 @@ func setupEnabled() {
 		personalPage(loggedUser).Render(r.Context(), w)
 	})
 	mux.HandleFunc("POST /"+global.Settings.Personal.HTTPBasePath+"/disable", disableHandler)
+	mux.HandleFunc("GET /"+global.Settings.Personal.HTTPBasePath+"/storage-key", storageKeyHandler)
+	mux.HandleFunc("POST /"+global.Settings.Personal.HTTPBasePath+"/storage-key", setupStorageKeyHandler)
+	mux.HandleFunc("GET /"+global.Settings.Personal.HTTPBasePath+"/export", exportHandler)
+	mux.HandleFunc("POST /"+global.Settings.Personal.HTTPBasePath+"/wipe", wipeHandler)
 	Relay.SetRouter(mux)
 }
 
@@ -143,6 +199,11 @@ func query(ctx context.Context, filter nostr.Filter) iter.Seq[nostr.Event] {
 		return func(yield func(nostr.Event) bool) {}
 	}
 
+	// events of members that set up encryption are stored in envelopes
+	if _, hasKey := storageKey(authed); hasKey || global.Settings.Personal.Encrypted {
+		return queryEncrypted(ctx, authed, filter)
+	}
+
 	db := global.IL.Personal
 
 	// if ids are given fetch such ids and check their authorship
@@ -261,7 +322,7 @@ func banEventHandler(ctx context.Context, id nostr.ID, reason string) error {
 		// check if the caller is the author of the event being banned
 		var isAuthor bool
 		for evt := range global.IL.Personal.QueryEvents(nostr.Filter{IDs: []nostr.ID{id}}, 1) {
-			if evt.PubKey == caller {
+			if ownerOf(evt) == caller {
 				isAuthor = true
 				break
 			}
@@ -272,5 +333,9 @@ func banEventHandler(ctx context.Context, id nostr.ID, reason string) error {
 		log.Info().Str("caller", caller.Hex()).Str("id", id.Hex()).Str("reason", reason).Msg("personal banevent called by author")
 	}
 
+	for evt := range global.IL.Personal.QueryEvents(nostr.Filter{IDs: []nostr.ID{id}}, 1) {
+		forgetUsage(ownerOf(evt))
+	}
+
 	return global.IL.Personal.DeleteEvent(id)
 }
@@ -0,0 +1,202 @@
+package personal
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"iter"
+	"net/http"
+	"slices"
+
+	"fiatjaf.com/nostr"
+	"github.com/dustin/go-humanize"
+	"github.com/puzpuzpuz/xsync/v3"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+)
+
+// Usage is how much a member has stored in the personal relay, counting events as they are stored
+// (so sealed events count the size of their envelopes).
+type Usage struct {
+	Events int
+	Size   int // in bytes
+	Kinds  []KindCount
+	Oldest nostr.Timestamp
+	Newest nostr.Timestamp
+}
+
+type KindCount struct {
+	Kind  nostr.Kind
+	Count int
+}
+
+type totals struct {
+	events int
+	size   int
+}
+
+// running totals for checking quotas without going through all events of a member on every write,
+// forgotten whenever something of theirs is deleted
+var usageTotals = xsync.NewMapOf[nostr.PubKey, totals]()
+
+// storedEvents goes through everything a member has in the personal relay, as it is stored.
+func storedEvents(member nostr.PubKey) iter.Seq[nostr.Event] {
+	return func(yield func(nostr.Event) bool) {
+		for evt := range global.IL.Personal.QueryEvents(nostr.Filter{Authors: []nostr.PubKey{member}}, 1_000_000) {
+			if !yield(evt) {
+				return
+			}
+		}
+		for evt := range global.IL.Personal.QueryEvents(nostr.Filter{
+			Kinds:   []nostr.Kind{envelopeKind},
+			Authors: []nostr.PubKey{global.Settings.RelayInternalSecretKey.Public()},
+			Tags:    nostr.TagMap{"p": []string{member.Hex()}},
+		}, 1_000_000) {
+			if !yield(evt) {
+				return
+			}
+		}
+	}
+}
+
+func usageOf(member nostr.PubKey) Usage {
+	var usage Usage
+	kinds := make(map[nostr.Kind]int)
+	for evt := range storedEvents(member) {
+		usage.Events++
+		usage.Size += len(evt.String())
+		kinds[kindOf(evt)]++
+		if usage.Oldest == 0 || evt.CreatedAt < usage.Oldest {
+			usage.Oldest = evt.CreatedAt
+		}
+		if evt.CreatedAt > usage.Newest {
+			usage.Newest = evt.CreatedAt
+		}
+	}
+
+	usage.Kinds = make([]KindCount, 0, len(kinds))
+	for kind, count := range kinds {
+		usage.Kinds = append(usage.Kinds, KindCount{kind, count})
+	}
+	slices.SortFunc(usage.Kinds, func(a, b KindCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Kind, b.Kind)
+	})
+
+	usageTotals.Store(member, totals{usage.Events, usage.Size})
+	return usage
+}
+
+func totalsOf(member nostr.PubKey) totals {
+	if t, ok := usageTotals.Load(member); ok {
+		return t
+	}
+	usage := usageOf(member)
+	return totals{usage.Events, usage.Size}
+}
+
+func countStored(evt nostr.Event) {
+	usageTotals.Compute(ownerOf(evt), func(t totals, loaded bool) (totals, bool) {
+		if !loaded {
+			// will be computed from scratch when needed
+			return t, true
+		}
+		return totals{t.events + 1, t.size + len(evt.String())}, false
+	})
+}
+
+// countReplaced takes the versions an event replaced out of the totals and adds it, if it was stored
+// (it isn't when a newer version was already there).
+func countReplaced(stored nostr.Event, replaced []nostr.Event) {
+	wasStored := len(replaced) > 0
+	if !wasStored {
+		for range global.IL.Personal.QueryEvents(nostr.Filter{IDs: []nostr.ID{stored.ID}}, 1) {
+			wasStored = true
+		}
+	}
+
+	usageTotals.Compute(ownerOf(stored), func(t totals, loaded bool) (totals, bool) {
+		if !loaded {
+			// will be computed from scratch when needed
+			return t, true
+		}
+		for _, previous := range replaced {
+			t.events--
+			t.size -= len(previous.String())
+		}
+		if wasStored {
+			t.events++
+			t.size += len(stored.String())
+		}
+		return t, false
+	})
+}
+
+func forgetUsage(member nostr.PubKey) {
+	usageTotals.Delete(member)
+}
+
+// exceedsQuota tells if storing an event would take a member over the limits of their level.
+func exceedsQuota(ctx context.Context, evt nostr.Event) (bool, string) {
+	maxEvents, maxSize := pyramid.GetPersonalQuotaFor(evt.PubKey)
+	if maxEvents == 0 && maxSize == 0 {
+		return false, ""
+	}
+
+	// deletions are always allowed since they are how members free up space
+	if evt.Kind == 5 {
+		return false, ""
+	}
+
+	t := totalsOf(evt.PubKey)
+	if maxEvents > 0 && t.events >= maxEvents && !evt.Kind.IsReplaceable() {
+		return true, fmt.Sprintf("blocked: you've reached your limit of %d events in personal storage", maxEvents)
+	}
+	if maxSize > 0 && t.size+len(evt.String()) > maxSize*1024 {
+		return true, fmt.Sprintf("blocked: you've reached your limit of %s in personal storage", humanize.Bytes(uint64(maxSize*1024)))
+	}
+	return false, ""
+}
+
+// exportHandler sends all personal events of the logged member as JSONL, as they are stored.
+// envelopes are opened by the page, which is the one that can get to the storage key.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsMember(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="personal-%s.jsonl"`, nostr.Now().Time().Format("2006-01-02")))
+	for evt := range storedEvents(loggedUser) {
+		fmt.Fprintln(w, evt.String())
+	}
+}
+
+// wipeHandler deletes everything the logged member has in the personal relay, including their storage key.
+func wipeHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsMember(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	ids := make([]nostr.ID, 0, 100)
+	for evt := range storedEvents(loggedUser) {
+		ids = append(ids, evt.ID)
+	}
+	for _, id := range ids {
+		if err := global.IL.Personal.DeleteEvent(id); err != nil {
+			log.Error().Err(err).Str("id", id.Hex()).Msg("failed to delete personal event")
+		}
+	}
+
+	forgetUsage(loggedUser)
+	log.Info().Str("member", loggedUser.Hex()).Int("events", len(ids)).Msg("personal storage wiped")
+
+	http.Redirect(w, r, global.Settings.Personal.GetPageURL(), 302)
+}
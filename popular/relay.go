@@ -11,6 +11,7 @@ import (
 	"fiatjaf.com/nostr/khatru/policies"
 	"fiatjaf.com/nostr/nip11"
 
+	"github.com/fiatjaf/pyramid/feed"
 	"github.com/fiatjaf/pyramid/global"
 	"github.com/fiatjaf/pyramid/pyramid"
 )
@@ -90,6 +91,10 @@ func setupEnabled() {
 		popularPage(loggedUser).Render(r.Context(), w)
 	})
 	mux.HandleFunc("POST /"+global.Settings.Popular.HTTPBasePath+"/disable", disableHandler)
+	feed.Register(mux, func() global.RelayMetadata { return global.Settings.Popular.RelayMetadata }, global.IL.Popular)
+	mux.HandleFunc("GET /"+global.Settings.Popular.HTTPBasePath+"/digest.xml", func(w http.ResponseWriter, r *http.Request) {
+		feed.Serve(w, r, feed.Digests(global.Settings.Popular.RelayMetadata, global.IL.Popular))
+	})
 	Relay.SetRouter(mux)
 }
 
@@ -0,0 +1,124 @@
+package pyramid
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"fiatjaf.com/nostr"
+
+	"github.com/fiatjaf/pyramid/global"
+)
+
+// KindManagementAction is the kind of the events that make up the management log.
+// each one has a managementAction as its content and points to the previous one with a "prev" tag,
+// so the whole history of the invite tree can be verified by anyone who knows the relay pubkey.
+const KindManagementAction nostr.Kind = 8534
+
+var (
+	logMutex     sync.Mutex
+	lastActionID nostr.ID
+)
+
+func actionToEvent(action managementAction, prev nostr.ID) (nostr.Event, error) {
+	content, err := json.Marshal(action)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	evt := nostr.Event{
+		Kind:      KindManagementAction,
+		CreatedAt: action.When,
+		Tags:      nostr.Tags{{"type", string(action.Type)}},
+		Content:   string(content),
+	}
+	if prev != nostr.ZeroID {
+		evt.Tags = append(evt.Tags, nostr.Tag{"prev", prev.Hex()})
+	}
+
+	if err := evt.Sign(global.Settings.RelayInternalSecretKey); err != nil {
+		return nostr.Event{}, fmt.Errorf("failed to sign management action: %w", err)
+	}
+	return evt, nil
+}
+
+func actionFromEvent(evt nostr.Event, prev nostr.ID, signer nostr.PubKey) (managementAction, error) {
+	var action managementAction
+
+	if evt.PubKey != signer {
+		return action, fmt.Errorf("action %s signed by %s, not by the relay", evt.ID, evt.PubKey)
+	}
+	if !evt.CheckID() {
+		return action, fmt.Errorf("action %s has an invalid id", evt.ID)
+	}
+	if !evt.VerifySignature() {
+		return action, fmt.Errorf("action %s has an invalid signature", evt.ID)
+	}
+
+	prevTag := evt.Tags.Find("prev")
+	if prev == nostr.ZeroID {
+		if prevTag != nil {
+			return action, fmt.Errorf("action %s should be the first but points to %s", evt.ID, prevTag[1])
+		}
+	} else if prevTag == nil || prevTag[1] != prev.Hex() {
+		return action, fmt.Errorf("action %s doesn't point to the previous action %s", evt.ID, prev)
+	}
+
+	if err := json.Unmarshal([]byte(evt.Content), &action); err != nil {
+		return action, fmt.Errorf("action %s has invalid content: %w", evt.ID, err)
+	}
+	return action, nil
+}
+
+// ExportActionLog writes the entire signed management log, one event per line.
+func ExportActionLog(w io.Writer) error {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	file, err := os.Open(filepath.Join(global.S.DataPath, "management.jsonl"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// VerifyActionLog checks an exported management log: every action must be signed by signer
+// and point to the one before it. it returns the number of actions and the id of the last one.
+func VerifyActionLog(r io.Reader, signer nostr.PubKey) (int, nostr.ID, error) {
+	prev := nostr.ZeroID
+	count := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var evt nostr.Event
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
+			return count, prev, fmt.Errorf("line %d: %w", count+1, err)
+		}
+		if evt.Kind != KindManagementAction {
+			return count, prev, fmt.Errorf("line %d: unexpected kind %d", count+1, evt.Kind)
+		}
+		if _, err := actionFromEvent(evt, prev, signer); err != nil {
+			return count, prev, fmt.Errorf("line %d: %w", count+1, err)
+		}
+
+		prev = evt.ID
+		count++
+	}
+
+	return count, prev, scanner.Err()
+}
@@ -0,0 +1,235 @@
+package pyramid
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fiatjaf.com/nostr"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fiatjaf/pyramid/global"
+)
+
+func TestSignedActionLog(t *testing.T) {
+	root1 := nostr.PubKey{1}
+	userA := nostr.PubKey{'A'}
+	userB := nostr.PubKey{'B'}
+
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
+	global.Settings.MaxInvitesAtEachLevel = nil
+	global.Settings.MaxInvitesPerPerson = 10
+	global.S.DataPath = t.TempDir()
+	AbsoluteKey = global.Settings.RelayInternalSecretKey.Public()
+	Members.Clear()
+	lastActionID = nostr.ZeroID
+
+	require.NoError(t, AddAction(ActionInvite, AbsoluteKey, root1))
+	require.NoError(t, AddAction(ActionInvite, root1, userA))
+	require.NoError(t, AddAction(ActionInvite, userA, userB))
+	require.NoError(t, AddAction(ActionDrop, root1, userB))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, ExportActionLog(buf))
+	exported := buf.String()
+
+	count, last, err := VerifyActionLog(strings.NewReader(exported), AbsoluteKey)
+	require.NoError(t, err)
+	require.Equal(t, 4, count)
+	require.Equal(t, lastActionID, last)
+
+	// a different signer isn't accepted
+	_, _, err = VerifyActionLog(strings.NewReader(exported), nostr.Generate().Public())
+	require.Error(t, err)
+
+	// removing an action from the middle breaks the chain
+	lines := strings.Split(strings.TrimSpace(exported), "\n")
+	tampered := strings.Join(append([]string{lines[0]}, lines[2:]...), "\n")
+	count, _, err = VerifyActionLog(strings.NewReader(tampered), AbsoluteKey)
+	require.Error(t, err)
+	require.Equal(t, 1, count)
+
+	// reloading yields the same state
+	Members.Clear()
+	require.NoError(t, LoadManagement())
+	require.Equal(t, map[nostr.PubKey][]nostr.PubKey{
+		root1: {AbsoluteKey},
+		userA: {root1},
+	}, getMembersMap())
+	require.Equal(t, last, lastActionID)
+}
+
+func TestUnsignedLogMigration(t *testing.T) {
+	root1 := nostr.PubKey{1}
+	userA := nostr.PubKey{'A'}
+
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
+	global.S.DataPath = t.TempDir()
+	AbsoluteKey = global.Settings.RelayInternalSecretKey.Public()
+	Members.Clear()
+	lastActionID = nostr.ZeroID
+
+	path := filepath.Join(global.S.DataPath, "management.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(
+		`{"type":"invite","author":"`+AbsoluteKey.Hex()+`","target":"`+root1.Hex()+`","when":1700000000}`+"\n"+
+			`{"type":"invite","author":"`+root1.Hex()+`","target":"`+userA.Hex()+`","when":1700000001}`+"\n",
+	), 0644))
+
+	require.NoError(t, LoadManagement())
+	require.Equal(t, map[nostr.PubKey][]nostr.PubKey{
+		root1: {AbsoluteKey},
+		userA: {root1},
+	}, getMembersMap())
+
+	// the old file is kept and the new one is a valid chain
+	_, err := os.Stat(path + ".unsigned")
+	require.NoError(t, err)
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	count, last, err := VerifyActionLog(file, AbsoluteKey)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	require.Equal(t, last, lastActionID)
+
+	// new actions continue the chain
+	require.NoError(t, AddAction(ActionDrop, root1, userA))
+	Members.Clear()
+	require.NoError(t, LoadManagement())
+	require.Equal(t, map[nostr.PubKey][]nostr.PubKey{
+		root1: {AbsoluteKey},
+	}, getMembersMap())
+}
+
+func TestImportRemoteLog(t *testing.T) {
+	root1 := nostr.PubKey{1}
+	userA := nostr.PubKey{'A'}
+	userB := nostr.PubKey{'B'}
+
+	// build the remote pyramid
+	remoteSecret := nostr.Generate()
+	global.Settings.RelayInternalSecretKey = remoteSecret
+	global.Settings.MaxInvitesAtEachLevel = nil
+	global.Settings.MaxInvitesPerPerson = 10
+	global.S.DataPath = t.TempDir()
+	AbsoluteKey = remoteSecret.Public()
+	Members.Clear()
+	lastActionID = nostr.ZeroID
+
+	require.NoError(t, AddAction(ActionInvite, AbsoluteKey, root1))
+	require.NoError(t, AddAction(ActionInvite, root1, userA))
+	require.NoError(t, AddAction(ActionInvite, userA, userB))
+	require.NoError(t, AddRoleAction(ActionCreateRole, root1, "mod", "moderator", "", "120", 1))
+	require.NoError(t, AddRoleAssignmentAction(ActionAssignRole, root1, userB, "mod"))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, ExportActionLog(buf))
+	exported := buf.String()
+
+	// now import it on a new one
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
+	global.S.DataPath = t.TempDir()
+	AbsoluteKey = global.Settings.RelayInternalSecretKey.Public()
+
+	// the root must be a root there
+	_, err := ImportRemoteLog(strings.NewReader(exported), remoteSecret.Public(), userA, false)
+	require.Error(t, err)
+
+	// the log must be signed by the remote relay
+	_, err = ImportRemoteLog(strings.NewReader(exported), nostr.Generate().Public(), root1, false)
+	require.Error(t, err)
+
+	count, err := ImportRemoteLog(strings.NewReader(exported), remoteSecret.Public(), root1, false)
+	require.NoError(t, err)
+	require.Equal(t, 5, count)
+
+	expected := map[nostr.PubKey][]nostr.PubKey{
+		root1: {AbsoluteKey},
+		userA: {root1},
+		userB: {userA},
+	}
+	require.Equal(t, expected, getMembersMap())
+	require.True(t, MemberHasRole(userB, "mod"))
+
+	// the local log is signed by us and replays to the same thing
+	Members.Clear()
+	Roles.Clear()
+	require.NoError(t, LoadManagement())
+	require.Equal(t, expected, getMembersMap())
+	require.True(t, MemberHasRole(userB, "mod"))
+
+	// won't overwrite an existing log
+	_, err = ImportRemoteLog(strings.NewReader(exported), remoteSecret.Public(), root1, false)
+	require.Error(t, err)
+
+	// unless told to, and a failed replacement leaves the current log alone
+	path := filepath.Join(global.S.DataPath, "management.jsonl")
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	_, err = ImportRemoteLog(strings.NewReader(exported), remoteSecret.Public(), userA, true)
+	require.Error(t, err)
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, current, after)
+	_, err = os.Stat(path + ".tmp")
+	require.True(t, os.IsNotExist(err))
+
+	count, err = ImportRemoteLog(strings.NewReader(exported), remoteSecret.Public(), root1, true)
+	require.NoError(t, err)
+	require.Equal(t, 5, count)
+	backups, _ := filepath.Glob(path + ".*")
+	require.Len(t, backups, 1)
+	backup, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	require.Equal(t, current, backup)
+
+	Members.Clear()
+	Roles.Clear()
+	require.NoError(t, LoadManagement())
+	require.Equal(t, expected, getMembersMap())
+}
+
+func TestImportPublishedLists(t *testing.T) {
+	root1 := nostr.PubKey{1}
+	userA := nostr.PubKey{'A'}
+	userB := nostr.PubKey{'B'}
+
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
+	global.S.DataPath = t.TempDir()
+	AbsoluteKey = global.Settings.RelayInternalSecretKey.Public()
+	Members.Clear()
+	Roles.Clear()
+	lastActionID = nostr.ZeroID
+
+	memberList := nostr.Event{
+		Kind: 13534,
+		Tags: nostr.Tags{
+			{"-"},
+			{"member", root1.Hex()},
+			{"member", userA.Hex(), "mod"},
+			{"member", userB.Hex()},
+		},
+	}
+	roles := []nostr.Event{
+		{
+			Kind: 33534,
+			Tags: nostr.Tags{{"-"}, {"d", "mod"}, {"label", "moderator"}, {"color", "120"}, {"order", "2"}},
+		},
+	}
+
+	count, err := ImportPublishedLists(memberList, roles, root1, false)
+	require.NoError(t, err)
+	require.Equal(t, 5, count)
+
+	require.Equal(t, map[nostr.PubKey][]nostr.PubKey{
+		root1: {AbsoluteKey},
+		userA: {root1},
+		userB: {root1},
+	}, getMembersMap())
+	require.True(t, MemberHasRole(userA, "mod"))
+	role, _ := Roles.Load("mod")
+	require.Equal(t, Role{ID: "mod", Label: "moderator", Color: "120", Order: 2}, role)
+}
@@ -0,0 +1,71 @@
+package pyramid
+
+import (
+	"fiatjaf.com/nostr"
+)
+
+// SubtreeHealth summarizes a member and everybody below them in the invite tree.
+type SubtreeHealth struct {
+	Size         int  // the member plus all their descendants
+	Inactive     int  // members that haven't published anything recently
+	NeverPosted  int  // members that never published anything
+	Dropped      int  // invitees that were dropped from within this subtree
+	Events       uint // all events published by the subtree
+	RecentEvents uint // events published by the subtree recently
+	MostlySilent bool // most members of a non-trivial subtree never posted
+}
+
+// MemberActivity is how many events a member has published, in total and recently.
+type MemberActivity struct {
+	Total  uint
+	Recent uint
+}
+
+// ComputeSubtreeHealth returns the health of the subtree under each member, given the
+// activity of each individual member (members absent from the map have never posted).
+func ComputeSubtreeHealth(activity map[nostr.PubKey]MemberActivity) map[nostr.PubKey]SubtreeHealth {
+	children := make(map[nostr.PubKey][]nostr.PubKey, Members.Size())
+	for pubkey, member := range Members.Range {
+		for _, parent := range member.Parents {
+			children[parent] = append(children[parent], pubkey)
+		}
+	}
+
+	result := make(map[nostr.PubKey]SubtreeHealth, Members.Size())
+	for pubkey := range Members.Range {
+		// since members can have multiple parents we must not count anyone twice
+		seen := map[nostr.PubKey]struct{}{pubkey: {}}
+		queue := []nostr.PubKey{pubkey}
+		var health SubtreeHealth
+		for len(queue) > 0 {
+			curr := queue[0]
+			queue = queue[1:]
+
+			member, _ := Members.Load(curr)
+			act := activity[curr]
+
+			health.Size++
+			health.Events += act.Total
+			health.RecentEvents += act.Recent
+			health.Dropped += member.DroppedInvitees + member.DroppedOwnInvitees
+			if act.Total == 0 {
+				health.NeverPosted++
+			}
+			if act.Recent == 0 {
+				health.Inactive++
+			}
+
+			for _, child := range children[curr] {
+				if _, ok := seen[child]; !ok {
+					seen[child] = struct{}{}
+					queue = append(queue, child)
+				}
+			}
+		}
+
+		health.MostlySilent = health.Size >= 3 && health.NeverPosted*2 > health.Size
+		result[pubkey] = health
+	}
+
+	return result
+}
@@ -0,0 +1,210 @@
+package pyramid
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"fiatjaf.com/nostr"
+
+	"github.com/fiatjaf/pyramid/global"
+)
+
+// ImportRemoteLog rebuilds the local management log from another pyramid's exported log
+// (as served at its /management.jsonl). the remote log must be signed by remoteKey and
+// root must be a root member there. actions authored by the remote relay are attributed to
+// our AbsoluteKey and everything is signed again with our own key.
+func ImportRemoteLog(r io.Reader, remoteKey nostr.PubKey, root nostr.PubKey, replace bool) (int, error) {
+	var actions []managementAction
+
+	prev := nostr.ZeroID
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var evt nostr.Event
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
+			return 0, fmt.Errorf("remote log line %d: %w", len(actions)+1, err)
+		}
+		action, err := actionFromEvent(evt, prev, remoteKey)
+		if err != nil {
+			return 0, fmt.Errorf("remote log line %d: %w", len(actions)+1, err)
+		}
+		prev = evt.ID
+
+		if action.Author == remoteKey.Hex() {
+			action.Author = AbsoluteKey.Hex()
+		}
+		actions = append(actions, action)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return writeImportedActions(actions, root, replace)
+}
+
+// ImportPublishedLists rebuilds the local management log from the kind 13534 member list and
+// the kind 33534 role definitions published by another pyramid. these don't say who invited whom,
+// so every member ends up as a direct child of root, and members that were removed there but are
+// still listed come back enabled.
+func ImportPublishedLists(memberList nostr.Event, roleDefinitions []nostr.Event, root nostr.PubKey, replace bool) (int, error) {
+	now := nostr.Now()
+	actions := make([]managementAction, 0, len(memberList.Tags)+len(roleDefinitions))
+
+	for _, evt := range roleDefinitions {
+		action := managementAction{
+			Type:   ActionCreateRole,
+			Author: root.Hex(),
+			When:   now,
+		}
+		for _, tag := range evt.Tags {
+			if len(tag) < 2 {
+				continue
+			}
+			switch tag[0] {
+			case "d":
+				action.RoleID = tag[1]
+				action.Target = tag[1]
+			case "label":
+				action.RoleLabel = tag[1]
+			case "description":
+				action.RoleDesc = tag[1]
+			case "color":
+				action.RoleColor = tag[1]
+			case "order":
+				action.RoleOrder, _ = strconv.Atoi(tag[1])
+			case "capability":
+				action.Capabilities = append(action.Capabilities, tag[1])
+			}
+		}
+		if action.RoleID == "" {
+			continue
+		}
+		capabilities := action.Capabilities
+		action.Capabilities = nil
+		actions = append(actions, action)
+		if len(capabilities) > 0 {
+			actions = append(actions, managementAction{
+				Type:         ActionSetRoleCapabilities,
+				Author:       root.Hex(),
+				Target:       action.RoleID,
+				RoleID:       action.RoleID,
+				Capabilities: capabilities,
+				When:         now,
+			})
+		}
+	}
+
+	actions = append(actions, managementAction{
+		Type:   ActionInvite,
+		Author: AbsoluteKey.Hex(),
+		Target: root.Hex(),
+		When:   now,
+	})
+
+	var assignments []managementAction
+	for tag := range memberList.Tags.FindAll("member") {
+		pubkey, err := nostr.PubKeyFromHex(tag[1])
+		if err != nil {
+			continue
+		}
+
+		if pubkey != root {
+			actions = append(actions, managementAction{
+				Type:   ActionInvite,
+				Author: root.Hex(),
+				Target: pubkey.Hex(),
+				When:   now,
+			})
+		}
+
+		for _, roleID := range tag[2:] {
+			assignments = append(assignments, managementAction{
+				Type:   ActionAssignRole,
+				Author: root.Hex(),
+				Target: pubkey.Hex(),
+				RoleID: roleID,
+				When:   now,
+			})
+		}
+	}
+
+	return writeImportedActions(append(actions, assignments...), root, replace)
+}
+
+func writeImportedActions(actions []managementAction, root nostr.PubKey, replace bool) (int, error) {
+	path := filepath.Join(global.S.DataPath, "management.jsonl")
+	if _, err := os.Stat(path); err == nil && !replace {
+		return 0, fmt.Errorf("a management log already exists")
+	}
+
+	// check the result before writing anything
+	Members.Clear()
+	Roles.Clear()
+	DropProposals.Clear()
+	for _, action := range actions {
+		applyAction(action)
+	}
+	member, _ := Members.Load(root)
+	if !slices.Contains(member.Parents, AbsoluteKey) {
+		Members.Clear()
+		Roles.Clear()
+		DropProposals.Clear()
+		forgetChildren()
+		return 0, fmt.Errorf("%s isn't a root member there", root.Hex())
+	}
+
+	// write everything to a separate file first so a failure midway leaves the current log untouched
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	prev := nostr.ZeroID
+	for i, action := range actions {
+		evt, err := actionToEvent(action, prev)
+		if err == nil {
+			var b []byte
+			if b, err = json.Marshal(evt); err == nil {
+				_, err = file.WriteString(string(b) + "\n")
+			}
+		}
+		if err != nil {
+			file.Close()
+			os.Remove(tmp)
+			return i, err
+		}
+		prev = evt.ID
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	// keep the current log around, a warm standby will do this repeatedly
+	if replace {
+		if err := os.Link(path, path+"."+strconv.FormatInt(time.Now().Unix(), 10)); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return 0, fmt.Errorf("couldn't keep a copy of the existing log: %w", err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	logMutex.Lock()
+	lastActionID = prev
+	logMutex.Unlock()
+
+	return len(actions), nil
+}
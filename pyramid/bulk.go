@@ -0,0 +1,247 @@
+package pyramid
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip19"
+)
+
+// BulkEntry is one parent link in a bulk import, the parent being AbsoluteKey for root members.
+type BulkEntry struct {
+	PubKey nostr.PubKey
+	Parent nostr.PubKey
+}
+
+// BulkPlan is the result of checking a bulk import against the current tree without applying anything.
+type BulkPlan struct {
+	Invites   []BulkEntry // new parent links that will be added
+	Unchanged []BulkEntry // parent links that already exist
+	Rejected  []string    // lines or entries that can't be imported, with the reason
+}
+
+// ParseMembersCSV reads lines of "pubkey,parent" (npub, nprofile or hex). the parent may be
+// "root" for root members or be omitted, in which case defaultParent is used.
+// a header line and lines starting with # are ignored.
+func ParseMembersCSV(r io.Reader, defaultParent nostr.PubKey) ([]BulkEntry, []string) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	var entries []BulkEntry
+	var rejected []string
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rejected = append(rejected, fmt.Sprintf("line %d: %s", line, err))
+			break
+		}
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "pubkey") {
+			continue
+		}
+
+		pubkey := parseBulkPubKey(record[0])
+		if pubkey == nostr.ZeroPK {
+			rejected = append(rejected, fmt.Sprintf("line %d: invalid pubkey %q", line, record[0]))
+			continue
+		}
+
+		parent := defaultParent
+		if len(record) > 1 && strings.TrimSpace(record[1]) != "" {
+			if parent = parseBulkPubKey(record[1]); parent == nostr.ZeroPK {
+				rejected = append(rejected, fmt.Sprintf("line %d: invalid parent %q", line, record[1]))
+				continue
+			}
+		}
+
+		entries = append(entries, BulkEntry{pubkey, parent})
+	}
+
+	return entries, rejected
+}
+
+// ParseMembersList takes the "p" tags of a kind 3 follow list or of a NIP-51 list
+// and turns each of them into an entry invited by parent.
+func ParseMembersList(evt nostr.Event, parent nostr.PubKey) ([]BulkEntry, []string) {
+	if evt.Kind != nostr.KindFollowList && (evt.Kind < 10000 || evt.Kind >= 40000) {
+		return nil, []string{fmt.Sprintf("kind %d is not a follow list or a NIP-51 list", evt.Kind)}
+	}
+
+	var entries []BulkEntry
+	var rejected []string
+	for tag := range evt.Tags.FindAll("p") {
+		pubkey, err := nostr.PubKeyFromHex(tag[1])
+		if err != nil {
+			rejected = append(rejected, fmt.Sprintf("invalid pubkey %q", tag[1]))
+			continue
+		}
+		entries = append(entries, BulkEntry{pubkey, parent})
+	}
+
+	return entries, rejected
+}
+
+// ParseMembersImport accepts either a CSV as in ParseMembersCSV or the JSON of a list event as in ParseMembersList.
+func ParseMembersImport(input string, defaultParent nostr.PubKey) ([]BulkEntry, []string) {
+	input = strings.TrimSpace(input)
+	if strings.HasPrefix(input, "{") {
+		var evt nostr.Event
+		if err := json.Unmarshal([]byte(input), &evt); err != nil {
+			return nil, []string{"invalid event json: " + err.Error()}
+		}
+		return ParseMembersList(evt, defaultParent)
+	}
+	return ParseMembersCSV(strings.NewReader(input), defaultParent)
+}
+
+func parseBulkPubKey(input string) nostr.PubKey {
+	input = strings.TrimSpace(input)
+	if strings.EqualFold(input, "root") {
+		return AbsoluteKey
+	}
+
+	if pfx, value, err := nip19.Decode(input); err == nil && pfx == "npub" {
+		return value.(nostr.PubKey)
+	} else if err == nil && pfx == "nprofile" {
+		return value.(nostr.ProfilePointer).PublicKey
+	} else if pk, err := nostr.PubKeyFromHex(input); err == nil {
+		return pk
+	}
+	return nostr.ZeroPK
+}
+
+// PlanBulkImport checks the entries in order against the current tree, so entries
+// may refer to parents that are only added earlier in the same batch.
+func PlanBulkImport(entries []BulkEntry) BulkPlan {
+	var plan BulkPlan
+
+	// parent links added by this batch so far
+	added := make(map[nostr.PubKey][]nostr.PubKey)
+	isAncestor := func(ancestor, target nostr.PubKey) bool {
+		seen := map[nostr.PubKey]struct{}{}
+		queue := []nostr.PubKey{target}
+		for len(queue) > 0 {
+			curr := queue[0]
+			queue = queue[1:]
+			member, _ := Members.Load(curr)
+			for _, parent := range slices.Concat(member.Parents, added[curr]) {
+				if parent == ancestor {
+					return true
+				}
+				if _, ok := seen[parent]; !ok {
+					seen[parent] = struct{}{}
+					queue = append(queue, parent)
+				}
+			}
+		}
+		return false
+	}
+
+	for _, entry := range entries {
+		npub := nip19.EncodeNpub(entry.PubKey)
+		switch {
+		case entry.PubKey == AbsoluteKey:
+			plan.Rejected = append(plan.Rejected, fmt.Sprintf("%s: the relay key can't be a member", npub))
+		case entry.PubKey == entry.Parent:
+			plan.Rejected = append(plan.Rejected, fmt.Sprintf("%s: can't invite themselves", npub))
+		case IsParentOf(entry.Parent, entry.PubKey) || slices.Contains(added[entry.PubKey], entry.Parent):
+			plan.Unchanged = append(plan.Unchanged, entry)
+		case entry.Parent != AbsoluteKey && !IsMember(entry.Parent) && len(added[entry.Parent]) == 0:
+			plan.Rejected = append(plan.Rejected, fmt.Sprintf("%s: parent %s is not a member",
+				npub, nip19.EncodeNpub(entry.Parent)))
+		case isAncestor(entry.PubKey, entry.Parent):
+			plan.Rejected = append(plan.Rejected, fmt.Sprintf("%s: is an ancestor of %s",
+				npub, nip19.EncodeNpub(entry.Parent)))
+		default:
+			added[entry.PubKey] = append(added[entry.PubKey], entry.Parent)
+			plan.Invites = append(plan.Invites, entry)
+		}
+	}
+
+	return plan
+}
+
+// ApplyBulkImport writes an invite action for each planned invite as if it was made by the parent.
+// only root members can do this and invite quotas are not applied, the plan checks the rest.
+func ApplyBulkImport(author nostr.PubKey, plan BulkPlan) (int, error) {
+	if !IsRoot(author) && author != AbsoluteKey {
+		return 0, fmt.Errorf("only root members can import members")
+	}
+
+	for i, entry := range plan.Invites {
+		if err := appendActionToFile(managementAction{
+			Type:   ActionInvite,
+			Author: entry.Parent.Hex(),
+			Target: entry.PubKey.Hex(),
+			When:   nostr.Now(),
+		}); err != nil {
+			return i, fmt.Errorf("failed to invite %s: %w", nip19.EncodeNpub(entry.PubKey), err)
+		}
+	}
+
+	return len(plan.Invites), nil
+}
+
+// ExportMembers returns every parent link of every active member, ordered so that
+// parents always come before the members they invited, which makes it importable again.
+// disabled members are left out, and the members below them are attached to their closest active ancestor.
+func ExportMembers() []BulkEntry {
+	type step struct {
+		pubkey nostr.PubKey
+		parent nostr.PubKey // who the children of this one are exported under
+	}
+
+	// walking breadth-first from the top guarantees each parent is listed before its children
+	var entries []BulkEntry
+	listed := map[BulkEntry]struct{}{}
+	seen := map[nostr.PubKey]struct{}{}
+	queue := []step{{AbsoluteKey, AbsoluteKey}}
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+		for child, member := range GetChildren(curr.pubkey) {
+			next := step{child, child}
+			if member.Removed {
+				next.parent = curr.parent
+			} else if entry := (BulkEntry{child, curr.parent}); entry.PubKey != entry.Parent {
+				if _, ok := listed[entry]; !ok {
+					listed[entry] = struct{}{}
+					entries = append(entries, entry)
+				}
+			}
+			if _, ok := seen[child]; !ok {
+				seen[child] = struct{}{}
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return entries
+}
+
+// WriteMembersCSV writes entries in the same format ParseMembersCSV reads.
+func WriteMembersCSV(w io.Writer, entries []BulkEntry) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"pubkey", "parent"})
+	for _, entry := range entries {
+		parent := "root"
+		if entry.Parent != AbsoluteKey {
+			parent = nip19.EncodeNpub(entry.Parent)
+		}
+		writer.Write([]string{nip19.EncodeNpub(entry.PubKey), parent})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,87 @@
+package pyramid
+
+import (
+	"fmt"
+	"slices"
+
+	"fiatjaf.com/nostr"
+)
+
+// capabilities can be attached to roles so root can delegate some powers without making anyone root.
+const (
+	CapModerate          = "moderate"           // approve or reject events in the moderated relay
+	CapPin               = "pin"                // set the pinned note of the main relay and sub-relays
+	CapInboxBans         = "inbox-bans"         // manage the inbox ban list
+	CapBlossomUnlimited  = "blossom-unlimited"  // no blossom storage limit
+	CapInternal          = "internal"           // publish to the internal relay
+	CapPersonalUnlimited = "personal-unlimited" // no personal storage limits
+)
+
+var Capabilities = []string{CapModerate, CapPin, CapInboxBans, CapBlossomUnlimited, CapInternal, CapPersonalUnlimited}
+
+// these are things every member can do by default, but once any role carries
+// the capability they become restricted to root and members with that role.
+var restrictingCapabilities = []string{CapModerate, CapInternal}
+
+// HasCapability tells if the member has the capability through any of their roles. root has all of them.
+func HasCapability(pubkey nostr.PubKey, capability string) bool {
+	if IsRoot(pubkey) {
+		return true
+	}
+	if !IsMember(pubkey) {
+		return false
+	}
+
+	member, _ := Members.Load(pubkey)
+	for _, rid := range member.Roles {
+		if role, ok := Roles.Load(rid); ok && slices.Contains(role.Capabilities, capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCapabilityDelegated tells if any role carries the given capability.
+func IsCapabilityDelegated(capability string) bool {
+	for _, role := range Roles.Range {
+		if slices.Contains(role.Capabilities, capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// MemberMay is for the things members can do by default (see restrictingCapabilities):
+// any member may do them unless the capability was given to some role, then only those with it can.
+func MemberMay(pubkey nostr.PubKey, capability string) bool {
+	if !IsMember(pubkey) {
+		return false
+	}
+	if slices.Contains(restrictingCapabilities, capability) && IsCapabilityDelegated(capability) {
+		return HasCapability(pubkey, capability)
+	}
+	return true
+}
+
+func SetRoleCapabilitiesAction(author nostr.PubKey, roleID string, capabilities []string) error {
+	if !IsRoot(author) {
+		return fmt.Errorf("only root users can manage roles")
+	}
+	if _, ok := Roles.Load(roleID); !ok {
+		return fmt.Errorf("role '%s' does not exist", roleID)
+	}
+	for _, capability := range capabilities {
+		if !slices.Contains(Capabilities, capability) {
+			return fmt.Errorf("unknown capability '%s'", capability)
+		}
+	}
+
+	return appendActionToFile(managementAction{
+		Type:         ActionSetRoleCapabilities,
+		Author:       author.Hex(),
+		Target:       roleID,
+		RoleID:       roleID,
+		Capabilities: capabilities,
+		When:         nostr.Now(),
+	})
+}
@@ -27,6 +27,21 @@ type Member struct {
 	Parents []nostr.PubKey
 	Removed bool
 	Roles   []string
+
+	// zero means the membership never expires / there is no probation
+	ExpiresAt      nostr.Timestamp
+	ProbationUntil nostr.Timestamp
+
+	// used by the invite quota policy
+	InvitedAt       nostr.Timestamp
+	DroppedInvitees int // invitees of this member that were dropped by someone above
+
+	// invitees this member dropped themselves (used only for the tree analytics)
+	DroppedOwnInvitees int
+
+	// a suspension only applies while SuspendedUntil is in the future (or zero with scopes set, meaning indefinitely)
+	SuspendedScopes []string
+	SuspendedUntil  nostr.Timestamp
 }
 
 type Role struct {
@@ -35,6 +50,8 @@ type Role struct {
 	Description string
 	Color       string
 	Order       int
+
+	Capabilities []string
 }
 
 type Action string
@@ -50,6 +67,13 @@ const (
 	ActionDeleteRole   = "deleterole"
 	ActionAssignRole   = "assignrole"
 	ActionUnassignRole = "unassignrole"
+	ActionProposeDrop  = "proposedrop"
+	ActionApproveDrop  = "approvedrop"
+	ActionCancelDrop   = "canceldrop"
+	ActionSuspend      = "suspend"
+	ActionUnsuspend    = "unsuspend"
+
+	ActionSetRoleCapabilities = "setrolecaps"
 )
 
 func IsMember(pubkey nostr.PubKey) bool {
@@ -62,6 +86,11 @@ func IsRoot(pubkey nostr.PubKey) bool {
 	return slices.Contains(member.Parents, AbsoluteKey)
 }
 
+func IsOnProbation(pubkey nostr.PubKey) bool {
+	member, _ := Members.Load(pubkey)
+	return member.ProbationUntil > nostr.Now()
+}
+
 func MemberHasRole(pubkey nostr.PubKey, roleID string) bool {
 	member, ok := Members.Load(pubkey)
 	if !ok {
@@ -123,38 +152,17 @@ func GetLevel(pubkey nostr.PubKey) int {
 	return minLevel
 }
 
+// GetMaxInvitesFor returns the total from GetInviteQuota, which starts from the per-person or
+// per-level limit and then applies the age/activity bonuses and dropped invitee penalties.
 func GetMaxInvitesFor(pubkey nostr.PubKey) int {
-	if len(global.Settings.MaxInvitesAtEachLevel) > 0 {
-		level := GetLevel(pubkey)
-
-		if level < 0 {
-			return 0
-		}
-
-		// level 0 has unlimited invites
-		if level == 0 {
-			return 999999
-		}
-
-		// array starts from level 1, so use level-1 as index
-		adjustedLevel := level - 1
-		if adjustedLevel < len(global.Settings.MaxInvitesAtEachLevel) {
-			return global.Settings.MaxInvitesAtEachLevel[adjustedLevel]
-		}
+	return GetInviteQuota(pubkey).Total
+}
 
-		// if level is beyond the array, no invites are allowed
+func GetMaxBlossomUploadSizeFor(pubkey nostr.PubKey) int {
+	if HasCapability(pubkey, CapBlossomUnlimited) {
 		return 0
 	}
 
-	if IsRoot(pubkey) {
-		// root has unlimited invites
-		return 999999
-	}
-
-	return global.Settings.MaxInvitesPerPerson
-}
-
-func GetMaxBlossomUploadSizeFor(pubkey nostr.PubKey) int {
 	if len(global.Settings.Blossom.MaxUserUploadSizeAtEachLevel) > 0 {
 		level := GetLevel(pubkey)
 		if level < 1 {
@@ -171,6 +179,32 @@ func GetMaxBlossomUploadSizeFor(pubkey nostr.PubKey) int {
 	return global.Settings.Blossom.MaxUserUploadSize
 }
 
+// GetPersonalQuotaFor returns how many events and how many kilobytes the member can keep in
+// the personal relay, with 0 meaning unlimited.
+func GetPersonalQuotaFor(pubkey nostr.PubKey) (maxEvents int, maxSize int) {
+	if HasCapability(pubkey, CapPersonalUnlimited) {
+		return 0, 0
+	}
+
+	level := GetLevel(pubkey)
+	return atLevel(level, global.Settings.Personal.MaxEvents, global.Settings.Personal.MaxEventsAtEachLevel),
+		atLevel(level, global.Settings.Personal.MaxSize, global.Settings.Personal.MaxSizeAtEachLevel)
+}
+
+// atLevel picks the value for a member at a level from a per-level list, the last one applying to all levels below.
+func atLevel(level int, single int, levels []int) int {
+	if len(levels) == 0 {
+		return single
+	}
+	if level < 1 {
+		return 0
+	}
+	if level-1 < len(levels) {
+		return levels[level-1]
+	}
+	return levels[len(levels)-1]
+}
+
 func GetInviteCount(pubkey nostr.PubKey) int {
 	count := 0
 	for _, member := range Members.Range {
@@ -190,6 +224,10 @@ func CanInviteMore(pubkey nostr.PubKey) bool {
 		return false
 	}
 
+	if IsOnProbation(pubkey) || IsSuspended(pubkey, ScopeInvite) {
+		return false
+	}
+
 	return GetInviteCount(pubkey) < GetMaxInvitesFor(pubkey)
 }
 
@@ -240,24 +278,68 @@ func HasSingleRootAncestor(ancestor nostr.PubKey, target nostr.PubKey) bool {
 }
 
 type managementAction struct {
-	Type      Action          `json:"type"`
-	Author    string          `json:"author"`
-	Target    string          `json:"target"`
-	RoleID    string          `json:"role_id,omitempty"`
-	RoleLabel string          `json:"role_label,omitempty"`
-	RoleDesc  string          `json:"role_desc,omitempty"`
-	RoleColor string          `json:"role_color,omitempty"`
-	RoleOrder int             `json:"role_order,omitempty"`
-	When      nostr.Timestamp `json:"when"`
+	Type         Action          `json:"type"`
+	Author       string          `json:"author"`
+	Target       string          `json:"target"`
+	RoleID       string          `json:"role_id,omitempty"`
+	RoleLabel    string          `json:"role_label,omitempty"`
+	RoleDesc     string          `json:"role_desc,omitempty"`
+	RoleColor    string          `json:"role_color,omitempty"`
+	RoleOrder    int             `json:"role_order,omitempty"`
+	Expires      nostr.Timestamp `json:"expires,omitempty"`
+	Probation    nostr.Timestamp `json:"probation,omitempty"`
+	Scopes       []string        `json:"scopes,omitempty"`
+	Capabilities []string        `json:"capabilities,omitempty"`
+	When         nostr.Timestamp `json:"when"`
 }
 
 func AddAction(type_ Action, author nostr.PubKey, target nostr.PubKey) error {
+	return addAction(managementAction{
+		Type:   type_,
+		Author: author.Hex(),
+		Target: target.Hex(),
+		When:   nostr.Now(),
+	})
+}
+
+// AddInviteAction is like AddAction(ActionInvite, ...), but the invited member may be
+// given an expiration time and a probation period (during which they can't invite anyone).
+// zero values mean no expiration and no probation.
+func AddInviteAction(author nostr.PubKey, target nostr.PubKey, expires nostr.Timestamp, probation nostr.Timestamp) error {
+	if expires != 0 && expires <= nostr.Now() {
+		return fmt.Errorf("expiration must be in the future")
+	}
+	if expires != 0 && probation > expires {
+		return fmt.Errorf("probation can't last longer than the membership")
+	}
+
+	return addAction(managementAction{
+		Type:      ActionInvite,
+		Author:    author.Hex(),
+		Target:    target.Hex(),
+		Expires:   expires,
+		Probation: probation,
+		When:      nostr.Now(),
+	})
+}
+
+func addAction(action managementAction) error {
+	type_ := action.Type
+	author, _ := nostr.PubKeyFromHexCheap(action.Author)
+	target, _ := nostr.PubKeyFromHexCheap(action.Target)
+
 	if !IsMember(author) && author != AbsoluteKey {
 		return fmt.Errorf("pubkey %s isn't an active member", author)
 	}
 
 	switch type_ {
 	case ActionInvite:
+		if IsOnProbation(author) && !IsRoot(author) {
+			return fmt.Errorf("members on probation can't invite")
+		}
+		if IsSuspended(author, ScopeInvite) {
+			return fmt.Errorf("suspended from inviting")
+		}
 		if !CanInviteMore(author) {
 			maxInvites := GetMaxInvitesFor(author)
 			return fmt.Errorf("cannot invite more than %d", maxInvites)
@@ -271,10 +353,20 @@ func AddAction(type_ Action, author nostr.PubKey, target nostr.PubKey) error {
 		if target == author {
 			return fmt.Errorf("can't invite yourself")
 		}
+		if (action.Expires != 0 || action.Probation != 0) && IsMember(target) {
+			return fmt.Errorf("can't set an expiration or probation for someone who is already a member")
+		}
 	case ActionDrop:
+		if CanApproveDrop(author, target) {
+			// trying to drop someone that already has a pending drop counts as a co-signature
+			return ApproveDrop(author, target)
+		}
 		if !IsAncestorOf(author, target) {
 			return fmt.Errorf("not an ancestor, can't drop")
 		}
+		if required := RequiredDropApprovals(target); required > 0 && author != AbsoluteKey {
+			return proposeDrop(author, target, required)
+		}
 	case ActionLeave:
 		// anyone can leave anytime
 	case ActionDisable:
@@ -288,14 +380,46 @@ func AddAction(type_ Action, author nostr.PubKey, target nostr.PubKey) error {
 		if !IsAncestorOf(author, target) {
 			return fmt.Errorf("not an ancestor, can't enable")
 		}
+	case ActionSuspend, ActionUnsuspend:
+		// moderators can keep anyone from publishing, but nothing else
+		moderating := action.Type == ActionSuspend && slices.Equal(action.Scopes, []string{ScopePublish}) &&
+			HasCapability(author, CapModerate)
+		if !IsAncestorOf(author, target) && !IsRoot(author) && !moderating {
+			return fmt.Errorf("not an ancestor, can't suspend")
+		}
+		if IsRoot(target) && author != AbsoluteKey {
+			return fmt.Errorf("root members can't be suspended")
+		}
 	}
 
-	return appendActionToFile(managementAction{
-		Type:   type_,
-		Author: author.Hex(),
-		Target: target.Hex(),
-		When:   nostr.Now(),
-	})
+	return appendActionToFile(action)
+}
+
+// DisableExpiredMembers writes an ActionDisable (authored by the AbsoluteKey) for every
+// active member whose membership has expired and returns the members that were disabled.
+// since these are normal actions in the log, replaying it yields the same state.
+func DisableExpiredMembers() ([]nostr.PubKey, error) {
+	now := nostr.Now()
+
+	var expired []nostr.PubKey
+	for pubkey, member := range Members.Range {
+		if member.ExpiresAt != 0 && member.ExpiresAt <= now && !member.Removed && len(member.Parents) > 0 {
+			expired = append(expired, pubkey)
+		}
+	}
+
+	for i, pubkey := range expired {
+		if err := appendActionToFile(managementAction{
+			Type:   ActionDisable,
+			Author: AbsoluteKey.Hex(),
+			Target: pubkey.Hex(),
+			When:   now,
+		}); err != nil {
+			return expired[0:i], err
+		}
+	}
+
+	return expired, nil
 }
 
 func AddRoleAction(type_ Action, author nostr.PubKey, roleID, label, desc, color string, order int) error {
@@ -342,19 +466,91 @@ func LoadManagement() error {
 	}
 	defer file.Close()
 
+	signer := global.Settings.RelayInternalSecretKey.Public()
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	lastActionID = nostr.ZeroID
+
+	var unsigned []managementAction
 	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+	for n := 1; scanner.Scan(); n++ {
+		var evt nostr.Event
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err == nil && evt.Kind == KindManagementAction {
+			action, err := actionFromEvent(evt, lastActionID, signer)
+			if err != nil {
+				return fmt.Errorf("management log line %d: %w", n, err)
+			}
+			lastActionID = evt.ID
+			applyAction(action)
+			continue
+		}
+
+		// older logs were just plain actions, one per line
 		var action managementAction
-		if err := json.Unmarshal([]byte(scanner.Text()), &action); err != nil {
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
 			return err
 		}
+		if lastActionID != nostr.ZeroID {
+			return fmt.Errorf("management log line %d: unsigned action after signed ones", n)
+		}
+		unsigned = append(unsigned, action)
 
 		applyAction(action)
 	}
-	return scanner.Err()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(unsigned) > 0 {
+		file.Close()
+		return migrateUnsignedLog(unsigned)
+	}
+
+	return nil
+}
+
+// migrateUnsignedLog rewrites a log of plain actions as a signed chain, keeping a backup of the old file.
+func migrateUnsignedLog(actions []managementAction) error {
+	path := filepath.Join(global.S.DataPath, "management.jsonl")
+
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+
+	prev := nostr.ZeroID
+	for _, action := range actions {
+		evt, err := actionToEvent(action, prev)
+		if err != nil {
+			return err
+		}
+		b, _ := json.Marshal(evt)
+		if _, err := tmp.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		prev = evt.ID
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path, path+".unsigned"); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	lastActionID = prev
+	global.Log.Info().Int("actions", len(actions)).Msg("migrated management log to signed events")
+	return nil
 }
 
 func applyAction(action managementAction) {
+	forgetChildren()
+
 	type_ := action.Type
 	author, _ := nostr.PubKeyFromHexCheap(action.Author)
 	target, _ := nostr.PubKeyFromHexCheap(action.Target)
@@ -378,6 +574,12 @@ func applyAction(action managementAction) {
 			return role, false
 		})
 		return
+	case ActionSetRoleCapabilities:
+		Roles.Compute(action.RoleID, func(role Role, loaded bool) (Role, bool) {
+			role.Capabilities = action.Capabilities
+			return role, !loaded
+		})
+		return
 	case ActionDeleteRole:
 		Roles.Delete(action.RoleID)
 		// also remove from all members
@@ -413,18 +615,51 @@ func applyAction(action managementAction) {
 			return member, false
 		})
 		return
+	case ActionProposeDrop:
+		DropProposals.Store(target, DropProposal{Proposer: author, CreatedAt: action.When})
+		return
+	case ActionApproveDrop:
+		DropProposals.Compute(target, func(proposal DropProposal, loaded bool) (DropProposal, bool) {
+			proposal.Approvals = append(proposal.Approvals, author)
+			return proposal, !loaded
+		})
+		return
+	case ActionCancelDrop:
+		DropProposals.Delete(target)
+		return
 	case ActionInvite:
 		Members.Compute(target, func(member Member, loaded bool) (newMember Member, delete bool) {
+			// terms only apply to who is joining (or rejoining after being disabled), an invite from
+			// someone else can't change the terms of an active member
+			if !loaded || member.Removed {
+				member.ExpiresAt = action.Expires
+				member.ProbationUntil = action.Probation
+			}
+
 			member.Parents = append(member.Parents, author)
 			member.Removed = false // when invited by someone else, a member is reenabled
+			if member.InvitedAt == 0 {
+				member.InvitedAt = action.When
+			}
 			return member, false
 		})
 	case ActionDrop:
+		DropProposals.Delete(target)
 		member, _ := Members.Load(target)
 
 		// remove parent links that trace back to author
 		for i := 0; i < len(member.Parents); {
 			if HasSingleRootAncestor(author, member.Parents[i]) {
+				parent := member.Parents[i]
+				Members.Compute(parent, func(p Member, loaded bool) (Member, bool) {
+					if parent == author {
+						p.DroppedOwnInvitees++
+					} else {
+						// the inviter didn't drop this one themselves, so it counts against them
+						p.DroppedInvitees++
+					}
+					return p, !loaded
+				})
 				member.Parents[i] = member.Parents[len(member.Parents)-1]
 				member.Parents = member.Parents[:len(member.Parents)-1]
 			} else {
@@ -443,6 +678,7 @@ func applyAction(action managementAction) {
 	case ActionLeave:
 		// when leaving unilaterally breaks all relationships it may still have with parents (and children too)
 		Members.Delete(target)
+		DropProposals.Delete(target)
 
 		// remove links to dropped nodes, deleting any node left without parents,
 		// repeating until the member set stabilises. a worklist (instead of
@@ -485,13 +721,33 @@ func applyAction(action managementAction) {
 	case ActionEnable:
 		Members.Compute(target, func(o Member, loaded bool) (Member, bool) {
 			o.Removed = false
+			o.ExpiresAt = 0 // otherwise an expired member would be disabled again right away
 			return o, false
 		})
+	case ActionSuspend:
+		Members.Compute(target, func(o Member, loaded bool) (Member, bool) {
+			o.SuspendedScopes = action.Scopes
+			o.SuspendedUntil = action.Expires
+			return o, !loaded
+		})
+	case ActionUnsuspend:
+		Members.Compute(target, func(o Member, loaded bool) (Member, bool) {
+			o.SuspendedScopes = nil
+			o.SuspendedUntil = 0
+			return o, !loaded
+		})
 	}
 }
 
 func appendActionToFile(action managementAction) error {
-	b, err := json.Marshal(action)
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	evt, err := actionToEvent(action, lastActionID)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(evt)
 	if err != nil {
 		return err
 	}
@@ -504,6 +760,7 @@ func appendActionToFile(action managementAction) error {
 	if _, err := file.WriteString(string(b) + "\n"); err != nil {
 		return err
 	}
+	lastActionID = evt.ID
 
 	// apply only the new action: replaying the whole file here would
 	// re-apply all previous actions on top of the current state,
@@ -2,6 +2,7 @@ package pyramid
 
 import (
 	"math"
+	"strings"
 	"testing"
 
 	"fiatjaf.com/nostr"
@@ -308,7 +309,9 @@ func TestDuplicateInviteBySamePubkey(t *testing.T) {
 	Members.Clear()
 	global.Settings.MaxInvitesAtEachLevel = nil
 	global.Settings.MaxInvitesPerPerson = 10
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
 	global.S.DataPath = t.TempDir()
+	lastActionID = nostr.ZeroID
 
 	// setup: AbsoluteKey -> user1 -> user2
 	applyAction(managementAction{Type: ActionInvite, Author: AbsoluteKey.Hex(), Target: user1.Hex()})
@@ -389,3 +392,517 @@ func TestGetMaxInvitesFor(t *testing.T) {
 	nonMember := nostr.PubKey{'Z'}
 	require.Equal(t, 0, GetMaxInvitesFor(nonMember))
 }
+
+func TestProbationAndExpiration(t *testing.T) {
+	root1 := nostr.PubKey{1}
+	userA := nostr.PubKey{'A'}
+	userB := nostr.PubKey{'B'}
+	userC := nostr.PubKey{'C'}
+
+	AbsoluteKey = nostr.MustPubKeyFromHex("4444444444444444444444444444444444444444444444444444444444444444")
+	Members.Clear()
+	global.Settings.MaxInvitesAtEachLevel = nil
+	global.Settings.MaxInvitesPerPerson = 10
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
+	global.S.DataPath = t.TempDir()
+	lastActionID = nostr.ZeroID
+
+	err := AddAction(ActionInvite, AbsoluteKey, root1)
+	require.NoError(t, err)
+
+	// userA is on probation: can publish (is a member) but can't invite
+	err = AddInviteAction(root1, userA, 0, nostr.Now()+60*60)
+	require.NoError(t, err)
+	require.True(t, IsMember(userA))
+	require.True(t, IsOnProbation(userA))
+	require.False(t, CanInviteMore(userA))
+	err = AddAction(ActionInvite, userA, userB)
+	require.Error(t, err)
+	require.Equal(t, "members on probation can't invite", err.Error())
+
+	// expiration must be in the future
+	err = AddInviteAction(root1, userB, nostr.Now()-10, 0)
+	require.Error(t, err)
+
+	// userB's membership expires, userC's doesn't
+	err = AddInviteAction(root1, userB, nostr.Now()+60*60, 0)
+	require.NoError(t, err)
+	err = AddAction(ActionInvite, root1, userC)
+	require.NoError(t, err)
+
+	disabled, err := DisableExpiredMembers()
+	require.NoError(t, err)
+	require.Empty(t, disabled)
+
+	// pretend time has passed
+	Members.Compute(userB, func(member Member, loaded bool) (Member, bool) {
+		member.ExpiresAt = nostr.Now() - 1
+		return member, false
+	})
+
+	disabled, err = DisableExpiredMembers()
+	require.NoError(t, err)
+	require.Equal(t, []nostr.PubKey{userB}, disabled)
+	require.False(t, IsMember(userB))
+	require.True(t, IsMember(userC))
+
+	// replaying the log yields the same state
+	Members.Clear()
+	require.NoError(t, LoadManagement())
+	require.False(t, IsMember(userB))
+	require.True(t, IsMember(userC))
+	require.True(t, IsOnProbation(userA))
+
+	// enabling clears the expiration so it doesn't get disabled again
+	err = AddAction(ActionEnable, root1, userB)
+	require.NoError(t, err)
+	require.True(t, IsMember(userB))
+	disabled, err = DisableExpiredMembers()
+	require.NoError(t, err)
+	require.Empty(t, disabled)
+
+	// inviting someone who is already a member doesn't change their terms
+	root2 := nostr.PubKey{2}
+	require.NoError(t, AddAction(ActionInvite, AbsoluteKey, root2))
+	err = AddInviteAction(root2, userC, nostr.Now()+60*60, 0)
+	require.Error(t, err)
+	require.NoError(t, AddAction(ActionInvite, root2, userA))
+	require.True(t, IsOnProbation(userA))
+}
+
+func TestInviteQuota(t *testing.T) {
+	root1 := nostr.PubKey{1}
+	userA := nostr.PubKey{'A'}
+	userB := nostr.PubKey{'B'}
+	userC := nostr.PubKey{'C'}
+	userD := nostr.PubKey{'D'}
+
+	AbsoluteKey = nostr.MustPubKeyFromHex("5555555555555555555555555555555555555555555555555555555555555555")
+	Members.Clear()
+	global.Settings.MaxInvitesAtEachLevel = nil
+	global.Settings.MaxInvitesPerPerson = 2
+	defer func() {
+		global.Settings.InviteQuota.ExtraInviteEveryDays = 0
+		global.Settings.InviteQuota.DroppedInviteePenalty = 0
+	}()
+
+	longAgo := nostr.Now() - 100*86400
+	applyAction(managementAction{Type: ActionInvite, Author: AbsoluteKey.Hex(), Target: root1.Hex(), When: longAgo})
+	applyAction(managementAction{Type: ActionInvite, Author: root1.Hex(), Target: userA.Hex(), When: longAgo})
+	applyAction(managementAction{Type: ActionInvite, Author: userA.Hex(), Target: userB.Hex(), When: nostr.Now()})
+	applyAction(managementAction{Type: ActionInvite, Author: userB.Hex(), Target: userC.Hex(), When: nostr.Now()})
+	applyAction(managementAction{Type: ActionInvite, Author: userB.Hex(), Target: userD.Hex(), When: nostr.Now()})
+
+	// no policy: same as before
+	require.Equal(t, 2, GetMaxInvitesFor(userA))
+	require.True(t, GetInviteQuota(root1).Unlimited)
+
+	// membership age
+	global.Settings.InviteQuota.ExtraInviteEveryDays = 30
+	require.Equal(t, 5, GetMaxInvitesFor(userA))
+	require.Equal(t, 2, GetMaxInvitesFor(userB))
+	global.Settings.InviteQuota.MaxExtraInvites = 1
+	require.Equal(t, 3, GetMaxInvitesFor(userA))
+	global.Settings.InviteQuota.MaxExtraInvites = 0
+	global.Settings.InviteQuota.ExtraInviteEveryDays = 0
+
+	// userA drops userC, whom userB invited: userB gets the penalty, userA gets half of it
+	applyAction(managementAction{Type: ActionDrop, Author: userA.Hex(), Target: userC.Hex()})
+	require.False(t, IsMember(userC))
+	memberB, _ := Members.Load(userB)
+	require.Equal(t, 1, memberB.DroppedInvitees)
+
+	global.Settings.InviteQuota.DroppedInviteePenalty = 2
+	require.Equal(t, 0, GetMaxInvitesFor(userB))
+	quota := GetInviteQuota(userA)
+	require.Equal(t, 1, quota.Penalty)
+	require.Equal(t, 1, quota.Total)
+
+	// dropping your own invitee doesn't count against you
+	applyAction(managementAction{Type: ActionDrop, Author: userB.Hex(), Target: userD.Hex()})
+	memberB, _ = Members.Load(userB)
+	require.Equal(t, 1, memberB.DroppedInvitees)
+
+	// changes to the tree are seen by the next quota computed
+	applyAction(managementAction{Type: ActionDrop, Author: userA.Hex(), Target: userB.Hex()})
+	require.Equal(t, 0, GetInviteQuota(userA).Penalty)
+}
+
+func TestSubtreeHealth(t *testing.T) {
+	root1 := nostr.PubKey{1}
+	userA := nostr.PubKey{'A'}
+	userB := nostr.PubKey{'B'}
+	userC := nostr.PubKey{'C'}
+	userD := nostr.PubKey{'D'}
+	userE := nostr.PubKey{'E'}
+
+	AbsoluteKey = nostr.MustPubKeyFromHex("6666666666666666666666666666666666666666666666666666666666666666")
+	Members.Clear()
+
+	// root1 -> userA -> (userB, userC, userD), root1 -> userE, userE -> userD
+	applyAction(managementAction{Type: ActionInvite, Author: AbsoluteKey.Hex(), Target: root1.Hex()})
+	applyAction(managementAction{Type: ActionInvite, Author: root1.Hex(), Target: userA.Hex()})
+	applyAction(managementAction{Type: ActionInvite, Author: root1.Hex(), Target: userE.Hex()})
+	applyAction(managementAction{Type: ActionInvite, Author: userA.Hex(), Target: userB.Hex()})
+	applyAction(managementAction{Type: ActionInvite, Author: userA.Hex(), Target: userC.Hex()})
+	applyAction(managementAction{Type: ActionInvite, Author: userA.Hex(), Target: userD.Hex()})
+	applyAction(managementAction{Type: ActionInvite, Author: userE.Hex(), Target: userD.Hex()})
+	applyAction(managementAction{Type: ActionDrop, Author: userA.Hex(), Target: userC.Hex()})
+
+	health := ComputeSubtreeHealth(map[nostr.PubKey]MemberActivity{
+		root1: {Total: 10, Recent: 2},
+		userA: {Total: 5, Recent: 0},
+		userE: {Total: 1, Recent: 1},
+	})
+
+	// userD is reachable through two paths but only counted once
+	require.Equal(t, 5, health[root1].Size)
+	require.Equal(t, uint(16), health[root1].Events)
+	require.Equal(t, uint(3), health[root1].RecentEvents)
+	require.Equal(t, 1, health[root1].Dropped)
+	require.False(t, health[root1].MostlySilent)
+
+	require.Equal(t, 3, health[userA].Size)
+	require.Equal(t, 3, health[userA].Inactive)
+	require.Equal(t, 2, health[userA].NeverPosted)
+	require.Equal(t, 1, health[userA].Dropped)
+	require.True(t, health[userA].MostlySilent)
+
+	require.Equal(t, 2, health[userE].Size)
+	require.False(t, health[userE].MostlySilent)
+}
+
+func TestDropQuorum(t *testing.T) {
+	root1 := nostr.PubKey{1}
+	root2 := nostr.PubKey{2}
+	userA := nostr.PubKey{'A'}
+	userB := nostr.PubKey{'B'}
+	userC := nostr.PubKey{'C'}
+	userD := nostr.PubKey{'D'}
+	userE := nostr.PubKey{'E'}
+	userF := nostr.PubKey{'F'}
+
+	AbsoluteKey = nostr.MustPubKeyFromHex("7777777777777777777777777777777777777777777777777777777777777777")
+	Members.Clear()
+	DropProposals.Clear()
+	global.Settings.MaxInvitesAtEachLevel = nil
+	global.Settings.MaxInvitesPerPerson = 10
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
+	global.S.DataPath = t.TempDir()
+	lastActionID = nostr.ZeroID
+	global.Settings.DropQuorum.DescendantsThreshold = 1
+	global.Settings.DropQuorum.CoSigners = 1
+	defer func() { global.Settings.DropQuorum.DescendantsThreshold = 0 }()
+
+	require.NoError(t, AddAction(ActionInvite, AbsoluteKey, root1))
+	require.NoError(t, AddAction(ActionInvite, AbsoluteKey, root2))
+	require.NoError(t, AddAction(ActionInvite, root1, userA))
+	require.NoError(t, AddAction(ActionInvite, userA, userB))
+	require.NoError(t, AddAction(ActionInvite, userB, userC))
+	require.NoError(t, AddAction(ActionInvite, root1, userD))
+
+	// userB has a single descendant, so it can be dropped directly
+	require.Equal(t, 0, RequiredDropApprovals(userB))
+
+	// userA has two, so dropping becomes a proposal
+	err := AddAction(ActionDrop, root1, userA)
+	require.ErrorIs(t, err, ErrDropPending)
+	require.True(t, IsMember(userA))
+	_, pending := DropProposals.Load(userA)
+	require.True(t, pending)
+
+	// proposer can't approve, and non-ancestors can't either
+	require.False(t, CanApproveDrop(root1, userA))
+	require.False(t, CanApproveDrop(userD, userA))
+	require.Error(t, ApproveDrop(userD, userA))
+	require.True(t, CanApproveDrop(root2, userA))
+
+	// the proposal survives a reload
+	Members.Clear()
+	DropProposals.Clear()
+	require.NoError(t, LoadManagement())
+	proposal, pending := DropProposals.Load(userA)
+	require.True(t, pending)
+	require.Equal(t, root1, proposal.Proposer)
+
+	// another root co-signs by trying to drop too
+	require.NoError(t, AddAction(ActionDrop, root2, userA))
+	require.False(t, IsMember(userA))
+	require.False(t, IsMember(userB))
+	require.False(t, IsMember(userC))
+	_, pending = DropProposals.Load(userA)
+	require.False(t, pending)
+
+	// cancellation
+	require.NoError(t, AddAction(ActionInvite, root1, userA))
+	require.NoError(t, AddAction(ActionInvite, userA, userB))
+	require.NoError(t, AddAction(ActionInvite, userA, userC))
+	require.ErrorIs(t, AddAction(ActionDrop, root1, userA), ErrDropPending)
+	require.Error(t, CancelDrop(userD, userA))
+	require.NoError(t, CancelDrop(root1, userA))
+	_, pending = DropProposals.Load(userA)
+	require.False(t, pending)
+	require.True(t, IsMember(userA))
+
+	// a proposal whose proposer leaves the tree above the target can't be approved anymore,
+	// but the remaining ancestors can cancel it or replace it with their own
+	require.NoError(t, AddAction(ActionInvite, userB, userE))
+	require.NoError(t, AddAction(ActionInvite, userB, userF))
+	require.NoError(t, AddAction(ActionInvite, userD, userB))
+	require.ErrorIs(t, AddAction(ActionDrop, userA, userB), ErrDropPending)
+	require.NoError(t, AddAction(ActionLeave, userA, userA))
+	require.True(t, IsMember(userB))
+	require.False(t, CanApproveDrop(root2, userB))
+	require.Error(t, ApproveDrop(root2, userB))
+	require.True(t, CanCancelDrop(userD, userB))
+	require.False(t, CanCancelDrop(userE, userB))
+	require.ErrorIs(t, AddAction(ActionDrop, userD, userB), ErrDropPending)
+	proposal, pending = DropProposals.Load(userB)
+	require.True(t, pending)
+	require.Equal(t, userD, proposal.Proposer)
+	require.False(t, CanCancelDrop(userF, userB))
+}
+
+func TestSuspension(t *testing.T) {
+	root1 := nostr.PubKey{1}
+	userA := nostr.PubKey{'A'}
+	userB := nostr.PubKey{'B'}
+	userC := nostr.PubKey{'C'}
+
+	AbsoluteKey = nostr.MustPubKeyFromHex("8888888888888888888888888888888888888888888888888888888888888888")
+	Members.Clear()
+	global.Settings.MaxInvitesAtEachLevel = nil
+	global.Settings.MaxInvitesPerPerson = 10
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
+	global.S.DataPath = t.TempDir()
+	lastActionID = nostr.ZeroID
+
+	require.NoError(t, AddAction(ActionInvite, AbsoluteKey, root1))
+	require.NoError(t, AddAction(ActionInvite, root1, userA))
+	require.NoError(t, AddAction(ActionInvite, userA, userB))
+
+	// only ancestors (or roots) can suspend, and only with known scopes
+	require.Error(t, AddSuspendAction(userB, userA, []string{ScopeInvite}, 0))
+	require.Error(t, AddSuspendAction(root1, userA, []string{"fly"}, 0))
+	require.Error(t, AddSuspendAction(userA, root1, []string{ScopeInvite}, 0))
+
+	require.NoError(t, AddSuspendAction(root1, userA, []string{ScopeInvite, ScopeBlossom}, nostr.Now()+60))
+	require.True(t, IsMember(userA))
+	require.True(t, IsSuspended(userA, ScopeInvite))
+	require.True(t, IsSuspended(userA, ScopeBlossom))
+	require.False(t, IsSuspended(userA, ScopePublish))
+	require.False(t, CanInviteMore(userA))
+	require.Error(t, AddAction(ActionInvite, userA, userC))
+
+	// it is kept across reloads
+	Members.Clear()
+	require.NoError(t, LoadManagement())
+	require.True(t, IsSuspended(userA, ScopeInvite))
+
+	// and lifted manually
+	require.NoError(t, AddAction(ActionUnsuspend, root1, userA))
+	require.False(t, IsSuspended(userA, ScopeInvite))
+	require.True(t, CanInviteMore(userA))
+
+	// or by itself once the time passes
+	applyAction(managementAction{Type: ActionSuspend, Author: root1.Hex(), Target: userA.Hex(), Scopes: []string{ScopePublish}, Expires: nostr.Now() - 1})
+	require.False(t, IsSuspended(userA, ScopePublish))
+	scopes, _ := GetSuspension(userA)
+	require.Empty(t, scopes)
+}
+
+func TestRoleCapabilities(t *testing.T) {
+	root1 := nostr.PubKey{1}
+	userA := nostr.PubKey{'A'}
+	userB := nostr.PubKey{'B'}
+
+	AbsoluteKey = nostr.MustPubKeyFromHex("9999999999999999999999999999999999999999999999999999999999999999")
+	Members.Clear()
+	Roles.Clear()
+	global.Settings.MaxInvitesAtEachLevel = nil
+	global.Settings.MaxInvitesPerPerson = 10
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
+	global.S.DataPath = t.TempDir()
+	lastActionID = nostr.ZeroID
+	defer Roles.Clear()
+
+	require.NoError(t, AddAction(ActionInvite, AbsoluteKey, root1))
+	require.NoError(t, AddAction(ActionInvite, root1, userA))
+	require.NoError(t, AddAction(ActionInvite, root1, userB))
+	require.NoError(t, AddRoleAction(ActionCreateRole, root1, "mod", "moderator", "", "120", 1))
+
+	// nothing delegated: everybody may moderate, nobody but root has the other capabilities
+	require.True(t, MemberMay(userA, CapModerate))
+	require.True(t, MemberMay(userB, CapModerate))
+	require.False(t, HasCapability(userA, CapPin))
+	require.True(t, HasCapability(root1, CapPin))
+
+	require.Error(t, SetRoleCapabilitiesAction(userA, "mod", []string{CapModerate}))
+	require.Error(t, SetRoleCapabilitiesAction(root1, "mod", []string{"fly"}))
+	require.Error(t, SetRoleCapabilitiesAction(root1, "nope", []string{CapModerate}))
+	require.NoError(t, SetRoleCapabilitiesAction(root1, "mod", []string{CapModerate, CapPin}))
+	require.NoError(t, AddRoleAssignmentAction(ActionAssignRole, root1, userA, "mod"))
+
+	// now moderation is restricted to those with the role
+	require.True(t, MemberMay(userA, CapModerate))
+	require.False(t, MemberMay(userB, CapModerate))
+	require.True(t, MemberMay(root1, CapModerate))
+
+	// moderators can suspend members that aren't below them, but only from publishing
+	require.Error(t, AddSuspendAction(userA, userB, []string{ScopeInvite}, 0))
+	require.NoError(t, AddSuspendAction(userA, userB, []string{ScopePublish}, nostr.Now()+60))
+	require.True(t, IsSuspended(userB, ScopePublish))
+	require.Error(t, AddAction(ActionUnsuspend, userA, userB))
+	require.NoError(t, AddAction(ActionUnsuspend, root1, userB))
+	require.True(t, HasCapability(userA, CapPin))
+	require.False(t, HasCapability(userB, CapPin))
+	require.True(t, MemberMay(userB, CapInternal))
+
+	// editing the role keeps its capabilities, and everything survives a reload
+	require.NoError(t, AddRoleAction(ActionEditRole, root1, "mod", "mods", "", "120", 1))
+	Members.Clear()
+	Roles.Clear()
+	require.NoError(t, LoadManagement())
+	require.True(t, HasCapability(userA, CapPin))
+	require.False(t, MemberMay(userB, CapModerate))
+
+	// blossom
+	require.NoError(t, SetRoleCapabilitiesAction(root1, "mod", []string{CapBlossomUnlimited}))
+	global.Settings.Blossom.MaxUserUploadSize = 10
+	defer func() { global.Settings.Blossom.MaxUserUploadSize = 0 }()
+	require.Equal(t, 0, GetMaxBlossomUploadSizeFor(userA))
+	require.Equal(t, 10, GetMaxBlossomUploadSizeFor(userB))
+
+	// personal
+	require.NoError(t, SetRoleCapabilitiesAction(root1, "mod", []string{CapPersonalUnlimited}))
+	global.Settings.Personal.MaxEvents = 100
+	global.Settings.Personal.MaxSizeAtEachLevel = []int{50, 20}
+	defer func() {
+		global.Settings.Personal.MaxEvents = 0
+		global.Settings.Personal.MaxSizeAtEachLevel = nil
+	}()
+	maxEvents, maxSize := GetPersonalQuotaFor(userA)
+	require.Equal(t, 0, maxEvents)
+	require.Equal(t, 0, maxSize)
+	maxEvents, maxSize = GetPersonalQuotaFor(userB)
+	require.Equal(t, 100, maxEvents)
+	require.Equal(t, 50, maxSize)
+}
+
+func TestBulkImportExport(t *testing.T) {
+	// these are parsed from text, so they must be valid keys
+	root1 := nostr.Generate().Public()
+	userA := nostr.Generate().Public()
+	userB := nostr.Generate().Public()
+	userC := nostr.Generate().Public()
+	userD := nostr.Generate().Public()
+
+	AbsoluteKey = nostr.MustPubKeyFromHex("9999999999999999999999999999999999999999999999999999999999999999")
+	Members.Clear()
+	global.Settings.MaxInvitesAtEachLevel = nil
+	global.Settings.MaxInvitesPerPerson = 1
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
+	global.S.DataPath = t.TempDir()
+	lastActionID = nostr.ZeroID
+
+	require.NoError(t, AddAction(ActionInvite, AbsoluteKey, root1))
+	require.NoError(t, AddAction(ActionInvite, root1, userA))
+
+	csv := "pubkey,parent\n" +
+		userA.Hex() + ",root\n" + // already invited by root1, but not by root
+		userA.Hex() + "," + root1.Hex() + "\n" + // unchanged
+		userB.Hex() + "," + userA.Hex() + "\n" +
+		userC.Hex() + "," + userB.Hex() + "\n" + // parent added earlier in the batch
+		userC.Hex() + "," + userA.Hex() + "\n" + // quotas don't apply
+		userA.Hex() + "," + userC.Hex() + "\n" + // would create a cycle
+		userD.Hex() + "," + nostr.Generate().Public().Hex() + "\n" + // parent isn't a member
+		"garbage\n" +
+		userD.Hex() + "\n" // uses the default parent
+	entries, rejected := ParseMembersCSV(strings.NewReader(csv), root1)
+	require.Len(t, rejected, 1)
+	require.Len(t, entries, 8)
+	require.Equal(t, AbsoluteKey, entries[0].Parent)
+	require.Equal(t, root1, entries[7].Parent)
+
+	plan := PlanBulkImport(entries)
+	require.Len(t, plan.Invites, 5)
+	require.Len(t, plan.Unchanged, 1)
+	require.Len(t, plan.Rejected, 2)
+
+	// the dry run changes nothing
+	require.False(t, IsMember(userB))
+
+	_, err := ApplyBulkImport(userA, plan)
+	require.Error(t, err)
+	applied, err := ApplyBulkImport(root1, plan)
+	require.NoError(t, err)
+	require.Equal(t, 5, applied)
+	require.True(t, IsRoot(userA))
+	require.True(t, IsParentOf(userB, userC))
+	require.True(t, IsParentOf(userA, userC))
+	require.True(t, IsParentOf(root1, userD))
+
+	// a list event invites everybody in it
+	list := nostr.Event{Kind: 30000, Tags: nostr.Tags{{"d", "x"}, {"p", userD.Hex()}, {"p", "nope"}}}
+	entries, rejected = ParseMembersList(list, userB)
+	require.Len(t, entries, 1)
+	require.Len(t, rejected, 1)
+	require.Equal(t, userB, entries[0].Parent)
+	_, rejected = ParseMembersList(nostr.Event{Kind: 1}, userB)
+	require.Len(t, rejected, 1)
+
+	// exporting and planning the export against the same tree changes nothing
+	exported := ExportMembers()
+	require.Len(t, exported, 7)
+	var buf strings.Builder
+	require.NoError(t, WriteMembersCSV(&buf, exported))
+	entries, rejected = ParseMembersImport(buf.String(), AbsoluteKey)
+	require.Empty(t, rejected)
+	plan = PlanBulkImport(entries)
+	require.Empty(t, plan.Invites)
+	require.Len(t, plan.Unchanged, 7)
+
+	// and importing it into an empty tree reproduces it
+	Members.Clear()
+	plan = PlanBulkImport(entries)
+	require.Empty(t, plan.Rejected)
+	require.Len(t, plan.Invites, 7)
+}
+
+func TestExportThroughDisabledMembers(t *testing.T) {
+	root1 := nostr.Generate().Public()
+	userA := nostr.Generate().Public()
+	userB := nostr.Generate().Public()
+	userC := nostr.Generate().Public()
+
+	AbsoluteKey = nostr.MustPubKeyFromHex("9999999999999999999999999999999999999999999999999999999999999999")
+	Members.Clear()
+	global.Settings.MaxInvitesAtEachLevel = nil
+	global.Settings.MaxInvitesPerPerson = 10
+	global.Settings.RelayInternalSecretKey = nostr.Generate()
+	global.S.DataPath = t.TempDir()
+	lastActionID = nostr.ZeroID
+
+	require.NoError(t, AddAction(ActionInvite, AbsoluteKey, root1))
+	require.NoError(t, AddAction(ActionInvite, root1, userA))
+	require.NoError(t, AddAction(ActionInvite, userA, userB))
+	require.NoError(t, AddAction(ActionInvite, userB, userC))
+	require.NoError(t, AddAction(ActionDisable, root1, userB))
+	require.False(t, IsMember(userB))
+	require.True(t, IsMember(userC))
+
+	// userC is still exported, under userA
+	exported := ExportMembers()
+	require.Equal(t, []BulkEntry{{root1, AbsoluteKey}, {userA, root1}, {userC, userA}}, exported)
+
+	// and survives a round trip
+	Members.Clear()
+	plan := PlanBulkImport(exported)
+	require.Empty(t, plan.Rejected)
+	_, err := ApplyBulkImport(AbsoluteKey, plan)
+	require.NoError(t, err)
+	Members.Clear()
+	require.NoError(t, LoadManagement())
+	require.True(t, IsMember(userC))
+	require.True(t, IsAncestorOf(userA, userC))
+}
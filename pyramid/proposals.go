@@ -0,0 +1,154 @@
+package pyramid
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"fiatjaf.com/nostr"
+	"github.com/puzpuzpuz/xsync/v3"
+
+	"github.com/fiatjaf/pyramid/global"
+)
+
+// ErrDropPending is returned when a drop wasn't executed because it still needs co-signatures.
+var ErrDropPending = errors.New("drop is pending approval")
+
+// DropProposal is a drop of a member with a large subtree that is waiting for
+// co-signatures from other ancestors of the target or from root members.
+type DropProposal struct {
+	Proposer  nostr.PubKey
+	Approvals []nostr.PubKey
+	CreatedAt nostr.Timestamp
+}
+
+// DropProposals holds the pending drop proposals keyed by their target
+var DropProposals = xsync.NewMapOf[nostr.PubKey, DropProposal]()
+
+func CountDescendants(pubkey nostr.PubKey) int {
+	seen := map[nostr.PubKey]struct{}{}
+	queue := []nostr.PubKey{pubkey}
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+		for child := range GetChildren(curr) {
+			if _, ok := seen[child]; !ok {
+				seen[child] = struct{}{}
+				queue = append(queue, child)
+			}
+		}
+	}
+	return len(seen)
+}
+
+// RequiredDropApprovals returns how many co-signatures dropping target would need, zero if it can be dropped directly.
+// only members with more descendants than the configured threshold need approval.
+func RequiredDropApprovals(target nostr.PubKey) int {
+	quorum := global.Settings.DropQuorum
+	if quorum.DescendantsThreshold <= 0 || CountDescendants(target) <= quorum.DescendantsThreshold {
+		return 0
+	}
+	return max(1, quorum.CoSigners)
+}
+
+// a proposal is stale when the tree changed and its proposer is no longer above the target,
+// it can't be executed anymore so it can only be canceled.
+func (proposal DropProposal) stale(target nostr.PubKey) bool {
+	return !IsAncestorOf(proposal.Proposer, target)
+}
+
+func CanApproveDrop(approver nostr.PubKey, target nostr.PubKey) bool {
+	proposal, ok := DropProposals.Load(target)
+	if !ok || approver == proposal.Proposer || slices.Contains(proposal.Approvals, approver) || proposal.stale(target) {
+		return false
+	}
+	return IsRoot(approver) || (IsMember(approver) && IsAncestorOf(approver, target))
+}
+
+// CanCancelDrop tells if someone can cancel the pending drop of target: the proposer and root members
+// always can, and any current ancestor can once the proposal is stale.
+func CanCancelDrop(author nostr.PubKey, target nostr.PubKey) bool {
+	proposal, ok := DropProposals.Load(target)
+	if !ok {
+		return false
+	}
+	if author == proposal.Proposer || IsRoot(author) {
+		return true
+	}
+	return proposal.stale(target) && IsMember(author) && IsAncestorOf(author, target)
+}
+
+// proposeDrop is called instead of dropping directly when the target has a large subtree.
+func proposeDrop(author nostr.PubKey, target nostr.PubKey, required int) error {
+	if proposal, exists := DropProposals.Load(target); exists {
+		if !proposal.stale(target) {
+			return fmt.Errorf("%w: %d more co-signatures needed", ErrDropPending, required-len(proposal.Approvals))
+		}
+		// the old proposal can't go anywhere, so it gets replaced by this one
+		if err := CancelDrop(author, target); err != nil {
+			return err
+		}
+	}
+
+	if err := appendActionToFile(managementAction{
+		Type:   ActionProposeDrop,
+		Author: author.Hex(),
+		Target: target.Hex(),
+		When:   nostr.Now(),
+	}); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %d co-signatures needed", ErrDropPending, required)
+}
+
+// ApproveDrop adds a co-signature to a pending drop proposal and executes the drop when the quorum is reached.
+func ApproveDrop(author nostr.PubKey, target nostr.PubKey) error {
+	proposal, ok := DropProposals.Load(target)
+	if !ok {
+		return fmt.Errorf("there is no pending drop for this member")
+	}
+	if proposal.stale(target) {
+		return fmt.Errorf("proposer is no longer an ancestor, this drop can only be canceled")
+	}
+	if !CanApproveDrop(author, target) {
+		return fmt.Errorf("only other ancestors or root members can approve this drop")
+	}
+
+	if err := appendActionToFile(managementAction{
+		Type:   ActionApproveDrop,
+		Author: author.Hex(),
+		Target: target.Hex(),
+		When:   nostr.Now(),
+	}); err != nil {
+		return err
+	}
+
+	required := RequiredDropApprovals(target)
+	if approvals := len(proposal.Approvals) + 1; approvals < required {
+		return fmt.Errorf("%w: %d more co-signatures needed", ErrDropPending, required-approvals)
+	}
+
+	// the drop is executed as if the proposer had done it
+	return appendActionToFile(managementAction{
+		Type:   ActionDrop,
+		Author: proposal.Proposer.Hex(),
+		Target: target.Hex(),
+		When:   nostr.Now(),
+	})
+}
+
+func CancelDrop(author nostr.PubKey, target nostr.PubKey) error {
+	if _, ok := DropProposals.Load(target); !ok {
+		return fmt.Errorf("there is no pending drop for this member")
+	}
+	if !CanCancelDrop(author, target) {
+		return fmt.Errorf("only the proposer or root members can cancel a drop, or any ancestor once the proposer isn't one anymore")
+	}
+
+	return appendActionToFile(managementAction{
+		Type:   ActionCancelDrop,
+		Author: author.Hex(),
+		Target: target.Hex(),
+		When:   nostr.Now(),
+	})
+}
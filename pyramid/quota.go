@@ -0,0 +1,170 @@
+package pyramid
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"github.com/puzpuzpuz/xsync/v3"
+
+	"github.com/fiatjaf/pyramid/global"
+)
+
+// InviteQuota is the breakdown of how many invites a member is allowed to have.
+type InviteQuota struct {
+	Base          int
+	AgeBonus      int
+	ActivityBonus int
+	Penalty       int
+	Total         int
+	Unlimited     bool
+	Reason        string
+}
+
+type cachedCount struct {
+	count uint32
+	at    time.Time
+}
+
+var eventCounts = xsync.NewMapOf[nostr.PubKey, cachedCount]()
+
+// countMainEvents returns how many events a member has in the main relay,
+// cached for a while since this is called for everybody when rendering pages.
+func countMainEvents(pubkey nostr.PubKey) uint32 {
+	if global.IL.Main == nil {
+		return 0
+	}
+
+	if cached, ok := eventCounts.Load(pubkey); ok && time.Since(cached.at) < time.Minute*10 {
+		return cached.count
+	}
+
+	count, err := global.IL.Main.CountEvents(nostr.Filter{Authors: []nostr.PubKey{pubkey}})
+	if err != nil {
+		return 0
+	}
+	eventCounts.Store(pubkey, cachedCount{count, time.Now()})
+	return count
+}
+
+// droppedInviteesPenalty is the penalty for the invitees this member had dropped by others
+// plus half of the penalty of each of their children, so bad invites also weigh on who is above.
+func droppedInviteesPenalty(pubkey nostr.PubKey) float64 {
+	if global.Settings.InviteQuota.DroppedInviteePenalty == 0 {
+		return 0
+	}
+
+	return subtreePenalty(pubkey, childrenMap(), make(map[nostr.PubKey]float64))
+}
+
+var (
+	// the children of each member, built when first needed and dropped by applyAction
+	childrenCache map[nostr.PubKey][]nostr.PubKey
+	childrenMutex sync.Mutex
+)
+
+func childrenMap() map[nostr.PubKey][]nostr.PubKey {
+	childrenMutex.Lock()
+	defer childrenMutex.Unlock()
+
+	if childrenCache == nil {
+		childrenCache = make(map[nostr.PubKey][]nostr.PubKey, Members.Size())
+		for pk, member := range Members.Range {
+			for _, parent := range member.Parents {
+				childrenCache[parent] = append(childrenCache[parent], pk)
+			}
+		}
+	}
+	return childrenCache
+}
+
+func forgetChildren() {
+	childrenMutex.Lock()
+	childrenCache = nil
+	childrenMutex.Unlock()
+}
+
+// subtreePenalty walks the tree with the children of each member computed beforehand,
+// remembering the penalty of each subtree since members with many parents are reached more than once.
+func subtreePenalty(pubkey nostr.PubKey, children map[nostr.PubKey][]nostr.PubKey, known map[nostr.PubKey]float64) float64 {
+	if penalty, ok := known[pubkey]; ok {
+		return penalty
+	}
+
+	member, _ := Members.Load(pubkey)
+	penalty := float64(member.DroppedInvitees * global.Settings.InviteQuota.DroppedInviteePenalty)
+	for _, child := range children[pubkey] {
+		penalty += subtreePenalty(child, children, known) / 2
+	}
+	known[pubkey] = penalty
+	return penalty
+}
+
+func GetInviteQuota(pubkey nostr.PubKey) InviteQuota {
+	if IsRoot(pubkey) {
+		return InviteQuota{Total: 999999, Unlimited: true, Reason: "root member"}
+	}
+
+	var quota InviteQuota
+	var reasons []string
+
+	level := GetLevel(pubkey)
+	if len(global.Settings.MaxInvitesAtEachLevel) > 0 {
+		if level < 0 {
+			return InviteQuota{Reason: "not in the tree"}
+		}
+		if level == 0 {
+			return InviteQuota{Total: 999999, Unlimited: true, Reason: "level 0"}
+		}
+		if level-1 < len(global.Settings.MaxInvitesAtEachLevel) {
+			quota.Base = global.Settings.MaxInvitesAtEachLevel[level-1]
+		}
+		reasons = append(reasons, fmt.Sprintf("%d at level %d", quota.Base, level))
+	} else {
+		quota.Base = global.Settings.MaxInvitesPerPerson
+		reasons = append(reasons, fmt.Sprintf("%d per person", quota.Base))
+	}
+
+	if !IsMember(pubkey) {
+		quota.Total = quota.Base
+		quota.Reason = "not an active member"
+		return quota
+	}
+
+	policy := global.Settings.InviteQuota
+	member, _ := Members.Load(pubkey)
+
+	if policy.ExtraInviteEveryDays > 0 && member.InvitedAt != 0 {
+		days := int(nostr.Now()-member.InvitedAt) / 86400
+		if quota.AgeBonus = days / policy.ExtraInviteEveryDays; quota.AgeBonus > 0 {
+			reasons = append(reasons, fmt.Sprintf("+%d for %d days as a member", quota.AgeBonus, days))
+		}
+	}
+
+	if policy.ExtraInviteEveryEvents > 0 {
+		count := int(countMainEvents(pubkey))
+		if quota.ActivityBonus = count / policy.ExtraInviteEveryEvents; quota.ActivityBonus > 0 {
+			reasons = append(reasons, fmt.Sprintf("+%d for %d events", quota.ActivityBonus, count))
+		}
+	}
+
+	if policy.MaxExtraInvites > 0 && quota.AgeBonus+quota.ActivityBonus > policy.MaxExtraInvites {
+		// take the excess from the activity bonus first
+		excess := quota.AgeBonus + quota.ActivityBonus - policy.MaxExtraInvites
+		cut := min(excess, quota.ActivityBonus)
+		quota.ActivityBonus -= cut
+		quota.AgeBonus -= excess - cut
+		reasons = append(reasons, fmt.Sprintf("extras capped at %d", policy.MaxExtraInvites))
+	}
+
+	if quota.Penalty = int(math.Floor(droppedInviteesPenalty(pubkey))); quota.Penalty > 0 {
+		reasons = append(reasons, fmt.Sprintf("-%d for dropped invitees", quota.Penalty))
+	}
+
+	quota.Total = max(0, quota.Base+quota.AgeBonus+quota.ActivityBonus-quota.Penalty)
+	quota.Reason = strings.Join(reasons, ", ")
+	return quota
+}
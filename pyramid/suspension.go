@@ -0,0 +1,65 @@
+package pyramid
+
+import (
+	"fmt"
+	"slices"
+
+	"fiatjaf.com/nostr"
+)
+
+// suspension scopes, each one restricts a single thing a member can do
+const (
+	ScopePublish = "publish" // can't publish to the main relay
+	ScopeInvite  = "invite"  // can't invite anyone
+	ScopeBlossom = "blossom" // can't upload blobs
+	ScopeGroups  = "groups"  // can't create groups
+)
+
+var SuspensionScopes = []string{ScopePublish, ScopeInvite, ScopeBlossom, ScopeGroups}
+
+// IsSuspended tells whether the member is currently suspended in the given scope.
+// suspensions with a time limit lift by themselves once it passes.
+func IsSuspended(pubkey nostr.PubKey, scope string) bool {
+	member, ok := Members.Load(pubkey)
+	if !ok || !slices.Contains(member.SuspendedScopes, scope) {
+		return false
+	}
+	return member.SuspendedUntil == 0 || member.SuspendedUntil > nostr.Now()
+}
+
+// GetSuspension returns the scopes a member is currently suspended from and until when (zero means indefinitely).
+func GetSuspension(pubkey nostr.PubKey) ([]string, nostr.Timestamp) {
+	member, _ := Members.Load(pubkey)
+	if len(member.SuspendedScopes) == 0 || (member.SuspendedUntil != 0 && member.SuspendedUntil <= nostr.Now()) {
+		return nil, 0
+	}
+	return member.SuspendedScopes, member.SuspendedUntil
+}
+
+// AddSuspendAction suspends a member from the given scopes until the given time (zero means until lifted manually).
+// a new suspension replaces the previous one entirely.
+func AddSuspendAction(author nostr.PubKey, target nostr.PubKey, scopes []string, until nostr.Timestamp) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("a suspension needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(SuspensionScopes, scope) {
+			return fmt.Errorf("unknown suspension scope '%s'", scope)
+		}
+	}
+	if until != 0 && until <= nostr.Now() {
+		return fmt.Errorf("suspension must end in the future")
+	}
+	if target == author {
+		return fmt.Errorf("can't suspend yourself")
+	}
+
+	return addAction(managementAction{
+		Type:    ActionSuspend,
+		Author:  author.Hex(),
+		Target:  target.Hex(),
+		Scopes:  scopes,
+		Expires: until,
+		When:    nostr.Now(),
+	})
+}
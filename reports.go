@@ -0,0 +1,312 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"iter"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/khatru"
+	"github.com/puzpuzpuz/xsync/v3"
+
+	"github.com/fiatjaf/pyramid/global"
+	"github.com/fiatjaf/pyramid/pyramid"
+	"github.com/fiatjaf/pyramid/wot"
+)
+
+// decisions on reports are kind 1985 events signed by the relay in this namespace,
+// with the decision as the label and the target in an "e" or "p" tag.
+const reportTriageNamespace = "pyramid/triage"
+
+const (
+	reportDecisionDismiss = "dismiss"
+	reportDecisionDelete  = "delete"
+	reportDecisionSuspend = "suspend"
+)
+
+// lastDecisions has the time of the latest decision taken on each target, loaded at startup
+// and kept up to date as decisions are recorded
+var lastDecisions = xsync.NewMapOf[string, nostr.Timestamp]()
+
+// hiddenByReports are the events in main hidden from everybody but moderators because enough members reported them
+var hiddenByReports = xsync.NewMapOf[nostr.ID, struct{}]()
+
+// reportedTarget groups the open reports against one event or one pubkey.
+type reportedTarget struct {
+	Key             string // "e:<id>" or "p:<pubkey>", as taken by reportDecisionHandler
+	EventID         nostr.ID
+	Event           *nostr.Event
+	PubKey          nostr.PubKey // the reported pubkey or the author of the reported event
+	Reports         []nostr.Event
+	MemberReporters int
+	Reasons         map[string]int
+	LastReport      nostr.Timestamp
+}
+
+// reportTargetKey tells what a report (or a decision) is about, reports about events take precedence.
+func reportTargetKey(evt nostr.Event) (key string, reason string, ok bool) {
+	if e := evt.Tags.Find("e"); e != nil {
+		if len(e) >= 3 {
+			reason = e[2]
+		}
+		return "e:" + e[1], reason, true
+	}
+	if p := evt.Tags.Find("p"); p != nil {
+		if len(p) >= 3 {
+			reason = p[2]
+		}
+		return "p:" + p[1], reason, true
+	}
+	return "", "", false
+}
+
+func noteReportDecision(key string, at nostr.Timestamp) {
+	lastDecisions.Compute(key, func(last nostr.Timestamp, loaded bool) (nostr.Timestamp, bool) {
+		return max(last, at), false
+	})
+}
+
+// loadReportDecisions indexes the decisions taken so far.
+func loadReportDecisions() {
+	for decision := range global.IL.ReportDecisions.QueryEvents(nostr.Filter{Kinds: []nostr.Kind{1985}}, 100_000) {
+		if key, _, ok := reportTargetKey(decision); ok {
+			noteReportDecision(key, decision.CreatedAt)
+		}
+	}
+}
+
+// collectOpenReports groups the reports that came after the last decision on their target,
+// the ones reported by more members first.
+func collectOpenReports(filter nostr.Filter) []*reportedTarget {
+	filter.Kinds = []nostr.Kind{1984}
+
+	targets := make(map[string]*reportedTarget)
+	reporters := make(map[string]map[nostr.PubKey]struct{})
+	for report := range global.IL.Main.QueryEvents(filter, 10_000) {
+		key, reason, ok := reportTargetKey(report)
+		if !ok {
+			continue
+		}
+		if decided, _ := lastDecisions.Load(key); report.CreatedAt <= decided {
+			continue
+		}
+
+		target, exists := targets[key]
+		if !exists {
+			target = &reportedTarget{Key: key, Reasons: make(map[string]int)}
+			targets[key] = target
+			reporters[key] = make(map[nostr.PubKey]struct{})
+		}
+		target.Reports = append(target.Reports, report)
+		target.LastReport = max(target.LastReport, report.CreatedAt)
+
+		// each member counts only once
+		if _, counted := reporters[key][report.PubKey]; !counted && pyramid.IsMember(report.PubKey) {
+			reporters[key][report.PubKey] = struct{}{}
+			target.MemberReporters++
+			if reason != "" {
+				target.Reasons[reason]++
+			}
+		}
+	}
+
+	list := make([]*reportedTarget, 0, len(targets))
+	for key, target := range targets {
+		if value, isEvent := strings.CutPrefix(key, "e:"); isEvent {
+			if id, err := nostr.IDFromHex(value); err == nil {
+				target.EventID = id
+				for evt := range global.IL.Main.QueryEvents(nostr.Filter{IDs: []nostr.ID{id}}, 1) {
+					target.Event = &evt
+					target.PubKey = evt.PubKey
+				}
+			}
+		} else if pk, err := nostr.PubKeyFromHex(strings.TrimPrefix(key, "p:")); err == nil {
+			target.PubKey = pk
+		}
+		list = append(list, target)
+	}
+	slices.SortFunc(list, func(a, b *reportedTarget) int {
+		if c := cmp.Compare(b.MemberReporters, a.MemberReporters); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.LastReport, a.LastReport)
+	})
+	return list
+}
+
+// loadHiddenByReports restores the auto-hidden events at startup.
+func loadHiddenByReports() {
+	threshold := global.Settings.Reports.AutoHideThreshold
+	if threshold <= 0 {
+		return
+	}
+	for _, target := range collectOpenReports(nostr.Filter{}) {
+		if target.Event != nil && target.MemberReporters >= threshold {
+			hiddenByReports.Store(target.EventID, struct{}{})
+		}
+	}
+}
+
+// processReport is called when a report is saved and applies the auto-hide and content-warning thresholds.
+func processReport(ctx context.Context, report nostr.Event) {
+	hideThreshold := global.Settings.Reports.AutoHideThreshold
+	labelThreshold := global.Settings.ContentWarnings.ReportThreshold
+	if hideThreshold <= 0 && labelThreshold <= 0 {
+		return
+	}
+
+	key, _, ok := reportTargetKey(report)
+	if !ok || !strings.HasPrefix(key, "e:") {
+		return
+	}
+
+	targets := collectOpenReports(nostr.Filter{Tags: nostr.TagMap{"e": []string{strings.TrimPrefix(key, "e:")}}})
+	for _, target := range targets {
+		if target.Key != key || target.Event == nil {
+			continue
+		}
+		if hideThreshold > 0 && target.MemberReporters >= hideThreshold {
+			if _, already := hiddenByReports.LoadOrStore(target.EventID, struct{}{}); !already {
+				log.Info().Str("event", target.EventID.Hex()).Int("reporters", target.MemberReporters).Msg("event hidden after reports")
+			}
+		}
+		if labelThreshold > 0 && target.MemberReporters >= labelThreshold {
+			labelReportedEvent(*target.Event, target.MemberReporters, target.Reasons)
+		}
+	}
+}
+
+// hideReportedEvents removes auto-hidden events from a query unless the reader is a moderator.
+func hideReportedEvents(ctx context.Context, query iter.Seq[nostr.Event]) iter.Seq[nostr.Event] {
+	if hiddenByReports.Size() == 0 {
+		return query
+	}
+	for _, authed := range khatru.GetAllAuthed(ctx) {
+		if pyramid.HasCapability(authed, pyramid.CapModerate) {
+			return query
+		}
+	}
+
+	return func(yield func(nostr.Event) bool) {
+		for evt := range query {
+			if _, hidden := hiddenByReports.Load(evt.ID); hidden {
+				continue
+			}
+			if !yield(evt) {
+				return
+			}
+		}
+	}
+}
+
+// reporterStatus describes where a reporter stands in relation to the relay.
+func reporterStatus(pubkey nostr.PubKey) string {
+	if pyramid.IsMember(pubkey) {
+		return fmt.Sprintf("member, level %d", pyramid.GetLevel(pubkey))
+	}
+	if wot.Contains(pubkey) {
+		return "in the web of trust"
+	}
+	return "outsider"
+}
+
+func reportsHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.HasCapability(loggedUser, pyramid.CapModerate) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	decisions := slices.Collect(global.IL.ReportDecisions.QueryEvents(nostr.Filter{Kinds: []nostr.Kind{1985}}, 50))
+	reportsPage(loggedUser, collectOpenReports(nostr.Filter{}), decisions).Render(r.Context(), w)
+}
+
+func reportDecisionHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.HasCapability(loggedUser, pyramid.CapModerate) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	key := r.PostFormValue("target")
+	decision := r.PostFormValue("decision")
+	note := strings.TrimSpace(r.PostFormValue("note"))
+
+	var target *reportedTarget
+	for _, t := range collectOpenReports(nostr.Filter{}) {
+		if t.Key == key {
+			target = t
+			break
+		}
+	}
+	if target == nil {
+		http.Error(w, "there are no open reports for this target", 404)
+		return
+	}
+
+	switch decision {
+	case reportDecisionDismiss:
+		if target.Event != nil {
+			hiddenByReports.Delete(target.EventID)
+			removeRelayLabels(target.EventID)
+		}
+	case reportDecisionDelete:
+		if target.Event == nil {
+			http.Error(w, "only reported events can be deleted", 400)
+			return
+		}
+		if err := global.IL.Main.DeleteEvent(target.EventID); err != nil {
+			http.Error(w, "failed to delete: "+err.Error(), 500)
+			return
+		}
+		handleDeleted(r.Context(), *target.Event)
+		hiddenByReports.Delete(target.EventID)
+	case reportDecisionSuspend:
+		if !pyramid.IsMember(target.PubKey) {
+			http.Error(w, "only members can be suspended", 400)
+			return
+		}
+		var until nostr.Timestamp
+		if days, _ := strconv.Atoi(r.PostFormValue("suspend_days")); days > 0 {
+			until = nostr.Now() + nostr.Timestamp(days*86400)
+		}
+		if err := pyramid.AddSuspendAction(loggedUser, target.PubKey, []string{pyramid.ScopePublish}, until); err != nil {
+			http.Error(w, "failed to suspend: "+err.Error(), 400)
+			return
+		}
+	default:
+		http.Error(w, "unknown decision", 400)
+		return
+	}
+
+	// record the decision
+	tagName, value, _ := strings.Cut(key, ":")
+	record := nostr.Event{
+		Kind:      1985,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"L", reportTriageNamespace},
+			{"l", decision, reportTriageNamespace},
+			{tagName, value},
+			{"moderator", loggedUser.Hex()},
+			{"reports", strconv.Itoa(len(target.Reports))},
+		},
+		Content: note,
+	}
+	if tagName == "e" {
+		record.Tags = append(record.Tags, nostr.Tag{"author", target.PubKey.Hex()})
+	}
+	record.Sign(global.Settings.RelayInternalSecretKey)
+	if err := global.IL.ReportDecisions.SaveEvent(record); err != nil {
+		log.Error().Err(err).Msg("failed to record report decision")
+	}
+	noteReportDecision(key, record.CreatedAt)
+	log.Info().Str("moderator", loggedUser.Hex()).Str("target", key).Str("decision", decision).Msg("report decision")
+
+	http.Redirect(w, r, "/reports", 302)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"net/http"
 	"strconv"
 
 	"fiatjaf.com/nostr"
@@ -27,6 +28,9 @@ func publishRoleDefinition(roleID string) {
 			{"order", strconv.Itoa(role.Order)},
 		},
 	}
+	for _, capability := range role.Capabilities {
+		evt.Tags = append(evt.Tags, nostr.Tag{"capability", capability})
+	}
 
 	evt.Sign(global.Settings.RelayInternalSecretKey)
 	if _, err := global.IL.Main.ReplaceEvent(evt); err != nil {
@@ -62,3 +66,20 @@ func publishRoleDefinitionDeletion(roleID string) {
 	del.Sign(global.Settings.RelayInternalSecretKey)
 	relay.BroadcastEvent(del)
 }
+
+func roleCapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	loggedUser, _ := global.GetLoggedUser(r)
+	if !pyramid.IsRoot(loggedUser) {
+		http.Error(w, "unauthorized", 403)
+		return
+	}
+
+	roleID := r.PostFormValue("role")
+	if err := pyramid.SetRoleCapabilitiesAction(loggedUser, roleID, r.PostForm["capability"]); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	go publishRoleDefinition(roleID)
+
+	http.Redirect(w, r, "/settings", 302)
+}
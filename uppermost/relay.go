@@ -11,6 +11,7 @@ import (
 	"fiatjaf.com/nostr/khatru/policies"
 	"fiatjaf.com/nostr/nip11"
 
+	"github.com/fiatjaf/pyramid/feed"
 	"github.com/fiatjaf/pyramid/global"
 	"github.com/fiatjaf/pyramid/pyramid"
 )
@@ -91,6 +92,10 @@ func setupEnabled() {
 		uppermostPage(loggedUser).Render(r.Context(), w)
 	})
 	mux.HandleFunc("POST /"+global.Settings.Uppermost.HTTPBasePath+"/disable", disableHandler)
+	feed.Register(mux, func() global.RelayMetadata { return global.Settings.Uppermost.RelayMetadata }, global.IL.Uppermost)
+	mux.HandleFunc("GET /"+global.Settings.Uppermost.HTTPBasePath+"/digest.xml", func(w http.ResponseWriter, r *http.Request) {
+		feed.Serve(w, r, feed.Digests(global.Settings.Uppermost.RelayMetadata, global.IL.Uppermost))
+	})
 	Relay.SetRouter(mux)
 }
 